@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertRule represents single alert rule.
+//
+// Rules have form of "VAR [rate] OP THRESHOLD [for DURATION]", like
+// "memstats.HeapAlloc > 512MB for 30s" or "Goroutines rate > 100/s",
+// or "down for N polls" for detecting services that are down.
+type AlertRule struct {
+	Text string
+
+	Var       VarName
+	Op        string
+	Threshold float64
+	For       time.Duration
+
+	// Down rules trigger when service fetch fails for Polls
+	// consecutive polls or for the For duration.
+	Down  bool
+	Polls int
+}
+
+var (
+	alertRuleRx = regexp.MustCompile(`^(\S+)(\s+rate)?\s+(>=|<=|==|!=|>|<)\s+(\S+)(?:\s+for\s+(\S+))?$`)
+	alertDownRx = regexp.MustCompile(`^down(?:\s+for\s+(\S+)(\s+polls?)?)?$`)
+)
+
+// ParseAlertRule parses alert rule from string.
+func ParseAlertRule(s string) (*AlertRule, error) {
+	s = strings.TrimSpace(s)
+	rule := &AlertRule{Text: s}
+
+	if m := alertDownRx.FindStringSubmatch(s); m != nil {
+		rule.Down = true
+		rule.Polls = 1
+		n, err := strconv.Atoi(m[1])
+		switch {
+		case m[1] == "":
+		case m[2] != "" || err == nil:
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid number of polls %q in alert rule %q", m[1], s)
+			}
+			rule.Polls = n
+		default:
+			d, err := time.ParseDuration(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration %q in alert rule %q", m[1], s)
+			}
+			rule.For = d
+		}
+		return rule, nil
+	}
+
+	m := alertRuleRx.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("cannot parse alert rule %q, expecting 'VAR > VALUE [for DURATION]'", s)
+	}
+
+	rule.Var = VarName(m[1])
+	if m[2] != "" && !rule.Var.Rate() {
+		rule.Var = rule.Var.WithModifier("rate")
+	}
+	rule.Op = m[3]
+
+	threshold, isRate, err := parseThreshold(m[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold %q in alert rule %q", m[4], s)
+	}
+	if isRate && !rule.Var.Rate() {
+		rule.Var = rule.Var.WithModifier("rate")
+	}
+	rule.Threshold = threshold
+
+	if m[5] != "" {
+		d, err := time.ParseDuration(m[5])
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q in alert rule %q", m[5], s)
+		}
+		rule.For = d
+	}
+	return rule, nil
+}
+
+// ParseAlertRules parses semicolon-separated list of alert rules.
+func ParseAlertRules(s string) ([]*AlertRule, error) {
+	var rules []*AlertRule
+	for _, str := range strings.Split(s, ";") {
+		if strings.TrimSpace(str) == "" {
+			continue
+		}
+		rule, err := ParseAlertRule(str)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// memorySuffixes used in thresholds, same as in Format output.
+var memorySuffixes = []struct {
+	suffix string
+	mult   float64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseThreshold parses threshold value, which can be a plain
+// number, memory size (512MB) or duration (10ms). Memory is converted
+// to bytes, duration to nanoseconds. Optional "/s" suffix means rate.
+func parseThreshold(s string) (float64, bool, error) {
+	isRate := strings.HasSuffix(s, "/s")
+	s = strings.TrimSuffix(s, "/s")
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, isRate, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return float64(d), isRate, nil
+	}
+	upper := strings.ToUpper(s)
+	for _, m := range memorySuffixes {
+		if !strings.HasSuffix(upper, m.suffix) {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s[:len(s)-len(m.suffix)]), 64)
+		if err != nil {
+			return 0, false, err
+		}
+		return f * m.mult, isRate, nil
+	}
+	return 0, false, fmt.Errorf("unknown threshold format")
+}
+
+// Matches returns true if rule is defined for the given var.
+func (r *AlertRule) Matches(name VarName) bool {
+	return !r.Down && r.Var.Long() == name.Long() && r.Var.Rate() == name.Rate()
+}
+
+// compare applies rule operator to the value.
+func (r *AlertRule) compare(val VarValue) bool {
+	f, ok := toFloat64(val)
+	if !ok {
+		return false
+	}
+	switch r.Op {
+	case ">":
+		return f > r.Threshold
+	case ">=":
+		return f >= r.Threshold
+	case "<":
+		return f < r.Threshold
+	case "<=":
+		return f <= r.Threshold
+	case "==":
+		return f == r.Threshold
+	case "!=":
+		return f != r.Threshold
+	}
+	return false
+}
+
+// AlertEvent represents alert state change for the service.
+type AlertEvent struct {
+	State   string    `json:"state"` // "firing" or "resolved"
+	Rule    string    `json:"rule"`
+	Service string    `json:"service"`
+	URL     string    `json:"url"`
+	Value   string    `json:"value,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// alertState tracks rule condition for the single service.
+type alertState struct {
+	since    time.Time
+	polls    int
+	attempts int // service attempts seen at the last check
+	firing   bool
+	value    string
+}
+
+type alertKey struct {
+	rule    *AlertRule
+	service *Service
+}
+
+// Alerts evaluates alert rules for services and fires
+// configured hooks when alerts trigger and resolve.
+type Alerts struct {
+	Rules []*AlertRule
+
+	// Command is a shell command to run on alert state changes,
+	// with JSON-encoded AlertEvent passed to stdin.
+	Command string
+	// Webhook is an URL to POST JSON-encoded AlertEvent to.
+	Webhook string
+
+	states map[alertKey]*alertState
+
+	// events are delivered to hooks one by one, in order,
+	// by a single worker goroutine
+	queue     chan AlertEvent
+	startOnce sync.Once
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+// alertQueueSize limits number of events waiting for delivery.
+const alertQueueSize = 64
+
+// NewAlerts returns new Alerts for the given rules.
+func NewAlerts(rules []*AlertRule) *Alerts {
+	return &Alerts{
+		Rules:  rules,
+		states: make(map[alertKey]*alertState),
+		queue:  make(chan AlertEvent, alertQueueSize),
+	}
+}
+
+// Vars returns all vars used in alert rules.
+func (a *Alerts) Vars() []VarName {
+	var vars []VarName
+	for _, rule := range a.Rules {
+		if !rule.Down {
+			vars = appendVars(vars, rule.Var)
+		}
+	}
+	return vars
+}
+
+// Check evaluates all rules for services, updated at the given time,
+// and fires hooks for alerts changed their state.
+func (a *Alerts) Check(services []*Service, now time.Time) []AlertEvent {
+	var events []AlertEvent
+	for _, rule := range a.Rules {
+		for _, service := range services {
+			key := alertKey{rule, service}
+			state, ok := a.states[key]
+			if !ok {
+				state = &alertState{}
+				a.states[key] = state
+			}
+			attempts := state.attempts
+			state.attempts = service.Attempts
+
+			var cond bool
+			if rule.Down {
+				cond = service.Err != nil
+			} else if stack, ok := service.stacks[rule.Var]; ok && service.Err == nil {
+				cond = rule.compare(stack.Front())
+				state.value = rule.Var.Format(stack.Front())
+			}
+
+			if !cond {
+				state.since, state.polls = time.Time{}, 0
+				if state.firing {
+					state.firing = false
+					events = append(events, a.event("resolved", rule, service, state, now))
+				}
+				continue
+			}
+
+			if state.polls == 0 {
+				state.since = now
+			}
+			// polls skipped while service is backing off are not counted
+			if service.Attempts != attempts || state.polls == 0 {
+				state.polls++
+			}
+			if state.firing || now.Sub(state.since) < rule.For || state.polls < rule.Polls {
+				continue
+			}
+			state.firing = true
+			events = append(events, a.event("firing", rule, service, state, now))
+		}
+	}
+
+	a.deliver(events)
+	return events
+}
+
+// deliver queues events for hooks, starting worker on first use.
+// Events are dropped, if hooks are too slow to keep up.
+func (a *Alerts) deliver(events []AlertEvent) {
+	if len(events) == 0 || (a.Command == "" && a.Webhook == "") {
+		return
+	}
+	a.startOnce.Do(func() { go a.worker() })
+	for _, e := range events {
+		select {
+		case a.queue <- e:
+		default:
+			a.setErr(fmt.Errorf("alert hooks are too slow, %s event for %q dropped", e.State, e.Rule))
+		}
+	}
+}
+
+// worker fires hooks for queued events.
+func (a *Alerts) worker() {
+	for e := range a.queue {
+		a.setErr(a.fire(e))
+	}
+}
+
+func (a *Alerts) event(state string, rule *AlertRule, service *Service, s *alertState, now time.Time) AlertEvent {
+	e := AlertEvent{
+		State:   state,
+		Rule:    rule.Text,
+		Service: service.Name,
+		URL:     RedactURL(service.URL),
+		Time:    now,
+	}
+	if !rule.Down {
+		e.Value = s.value
+	}
+	return e
+}
+
+// Reset clears state of all alerts.
+func (a *Alerts) Reset() {
+	a.states = make(map[alertKey]*alertState)
+}
+
+// Remove clears state of all alerts for the service.
+func (a *Alerts) Remove(service *Service) {
+	for key := range a.states {
+		if key.service == service {
+			delete(a.states, key)
+		}
+	}
+}
+
+// Firing returns true if any alert for service and var is active.
+func (a *Alerts) Firing(service *Service, name VarName) bool {
+	for _, rule := range a.Rules {
+		if rule.Matches(name) && a.firing(rule, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// Down returns true if any down alert for service is active.
+func (a *Alerts) Down(service *Service) bool {
+	for _, rule := range a.Rules {
+		if rule.Down && a.firing(rule, service) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Alerts) firing(rule *AlertRule, service *Service) bool {
+	state, ok := a.states[alertKey{rule, service}]
+	return ok && state.firing
+}
+
+// Active returns human-readable lines for all active alerts.
+func (a *Alerts) Active(services []*Service) []string {
+	var lines []string
+	for _, service := range services {
+		for _, rule := range a.Rules {
+			state, ok := a.states[alertKey{rule, service}]
+			if !ok || !state.firing {
+				continue
+			}
+			line := fmt.Sprintf("%s: %s since %s", service.Name, rule.Text, state.since.Format("15:04:05"))
+			if !rule.Down {
+				line += fmt.Sprintf(" (%s)", state.value)
+			}
+			lines = append(lines, line)
+		}
+	}
+	if err := a.Err(); err != nil {
+		lines = append(lines, fmt.Sprintf("alert hook failed: %v", err))
+	}
+	return lines
+}
+
+// Err returns last error of alert hooks, if any.
+func (a *Alerts) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastErr
+}
+
+func (a *Alerts) setErr(err error) {
+	a.mu.Lock()
+	a.lastErr = err
+	a.mu.Unlock()
+}
+
+// fire runs configured hooks for alert event.
+func (a *Alerts) fire(e AlertEvent) error {
+	payload, _ := json.Marshal(e)
+
+	var err error
+	if a.Command != "" {
+		cmd := exec.Command("sh", "-c", a.Command)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Env = append(os.Environ(),
+			"EXPVARMON_ALERT_STATE="+e.State,
+			"EXPVARMON_ALERT_RULE="+e.Rule,
+			"EXPVARMON_ALERT_SERVICE="+e.Service,
+			"EXPVARMON_ALERT_VALUE="+e.Value,
+		)
+		if out, cerr := cmd.CombinedOutput(); cerr != nil {
+			err = fmt.Errorf("command: %v: %s", cerr, bytes.TrimSpace(out))
+		}
+	}
+	if a.Webhook != "" {
+		client := &http.Client{Timeout: 5 * time.Second}
+		resp, werr := client.Post(a.Webhook, "application/json", bytes.NewReader(payload))
+		if werr != nil {
+			err = fmt.Errorf("webhook: %v", werr)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("webhook: %s", resp.Status)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/antonholmquist/jason"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	rule, err := ParseAlertRule("memstats.HeapAlloc > 512MB for 30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Var != "memstats.HeapAlloc" || rule.Op != ">" || rule.Threshold != 512*1024*1024 || rule.For != 30*time.Second {
+		t.Fatalf("Rule parsed incorrectly: %+v", rule)
+	}
+
+	rule, err = ParseAlertRule("Goroutines rate > 100/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Var != "rate:Goroutines" || rule.Threshold != 100 {
+		t.Fatalf("Rule parsed incorrectly: %+v", rule)
+	}
+
+	rule, err = ParseAlertRule("duration:memstats.PauseNs >= 10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Threshold != float64(10*time.Millisecond) || !rule.Matches("memstats.PauseNs") {
+		t.Fatalf("Rule parsed incorrectly: %+v", rule)
+	}
+
+	rule, err = ParseAlertRule("down for 3 polls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.Down || rule.Polls != 3 {
+		t.Fatalf("Rule parsed incorrectly: %+v", rule)
+	}
+
+	for _, s := range []string{"Goroutines", "Goroutines > lots", "Goroutines > 10 for ever", "down for -1 polls"} {
+		if _, err := ParseAlertRule(s); err == nil {
+			t.Fatalf("Expecting error for rule %q", s)
+		}
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	events := make(chan AlertEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e AlertEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer srv.Close()
+
+	rules, err := ParseAlertRules("goroutines > 5 for 10s; down for 2 polls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := NewAlerts(rules)
+	alerts.Webhook = srv.URL
+
+	service := NewService(NewURL("1234"), alerts.Vars())
+	update := func(goroutines string, err error, now time.Time) {
+		obj, _ := jason.NewObjectFromBytes([]byte(`{"cmdline": ["app"], "memstats": {"PauseTotalNs": 1}, "goroutines": ` + goroutines + `}`))
+		service.update(&Expvar{obj}, err, now)
+	}
+
+	start := time.Now()
+	update("10", nil, start)
+	if evs := alerts.Check([]*Service{service}, start); len(evs) != 0 {
+		t.Fatalf("Alert shouldn't fire before 10s, but got %v", evs)
+	}
+	update("10", nil, start.Add(10*time.Second))
+	evs := alerts.Check([]*Service{service}, start.Add(10*time.Second))
+	if len(evs) != 1 || evs[0].State != "firing" || evs[0].Value != "10" || evs[0].Service != "app" {
+		t.Fatalf("Expecting alert to fire, but got %v", evs)
+	}
+	if !alerts.Firing(service, "goroutines") || len(alerts.Active([]*Service{service})) != 1 {
+		t.Fatalf("Expecting alert to be active")
+	}
+
+	update("1", nil, start.Add(15*time.Second))
+	evs = alerts.Check([]*Service{service}, start.Add(15*time.Second))
+	if len(evs) != 1 || evs[0].State != "resolved" {
+		t.Fatalf("Expecting alert to resolve, but got %v", evs)
+	}
+	// hooks receive events in order
+	if e := <-events; e.State != "firing" || e.Rule != "goroutines > 5 for 10s" {
+		t.Fatalf("Webhook received wrong event: %v", e)
+	}
+	if e := <-events; e.State != "resolved" {
+		t.Fatalf("Webhook received wrong event: %v", e)
+	}
+
+	for i := 0; i < 2; i++ {
+		update("1", errors.New("connection refused"), start.Add(20*time.Second))
+		evs = alerts.Check([]*Service{service}, start.Add(20*time.Second))
+		if i == 0 {
+			// polls skipped while backing off are not counted
+			if evs := alerts.Check([]*Service{service}, start.Add(21*time.Second)); len(evs) != 0 {
+				t.Fatalf("Expecting skipped poll not to fire down alert, but got %v", evs)
+			}
+		}
+	}
+	if len(evs) != 1 || !alerts.Down(service) {
+		t.Fatalf("Expecting down alert to fire after 2 polls, but got %v", evs)
+	}
+	<-events
+}
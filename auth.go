@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuthOptions configures credentials, sent to the service.
+//
+// Credentials from the service URL take precedence, then bearer
+// token, then netrc lookup, then HTTP_USER/HTTP_PASSWORD env vars.
+type AuthOptions struct {
+	// Token is a static bearer token.
+	Token string
+	// TokenFile is a file with bearer token, re-read on change.
+	TokenFile string
+	// Netrc is a .netrc-style file to look up credentials by host.
+	Netrc string
+	// Headers are extra headers, sent with each request.
+	Headers http.Header
+}
+
+// IsZero returns true if no auth options are set.
+func (o AuthOptions) IsZero() bool {
+	return o.Token == "" && o.TokenFile == "" && o.Netrc == "" && len(o.Headers) == 0
+}
+
+// Merge returns options with non-empty values of other options
+// overriding the current ones. Headers are merged by name.
+func (o AuthOptions) Merge(other AuthOptions) AuthOptions {
+	// token and token file are alternatives
+	if other.Token != "" || other.TokenFile != "" {
+		o.Token, o.TokenFile = other.Token, other.TokenFile
+	}
+	if other.Netrc != "" {
+		o.Netrc = other.Netrc
+	}
+	if len(other.Headers) > 0 {
+		headers := make(http.Header)
+		for name, values := range o.Headers {
+			headers[name] = values
+		}
+		for name, values := range other.Headers {
+			headers[name] = values
+		}
+		o.Headers = headers
+	}
+	return o
+}
+
+// ParseHeader parses header in "Name: value" form.
+func ParseHeader(s string) (string, string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	name := strings.TrimSpace(parts[0])
+	if len(parts) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid header %q, should be in \"Name: value\" form", s)
+	}
+	return name, strings.TrimSpace(parts[1]), nil
+}
+
+// headerFlags is a repeatable flag with extra headers.
+type headerFlags struct {
+	http.Header
+}
+
+// String implements flag.Value.
+func (h *headerFlags) String() string {
+	var headers []string
+	for name, values := range h.Header {
+		headers = append(headers, name+": "+strings.Join(values, ","))
+	}
+	return strings.Join(headers, "; ")
+}
+
+// Set implements flag.Value.
+func (h *headerFlags) Set(s string) error {
+	name, value, err := ParseHeader(s)
+	if err != nil {
+		return err
+	}
+	if h.Header == nil {
+		h.Header = make(http.Header)
+	}
+	h.Header.Add(name, value)
+	return nil
+}
+
+// expandHome expands leading "~/" in path to user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// authTransport adds credentials to requests for the service host.
+// Requests to other hosts (i.e. after redirect) are sent as is.
+type authTransport struct {
+	Base      http.RoundTripper
+	Host      string
+	Headers   http.Header
+	Token     string
+	TokenFile *TokenFile
+	Netrc     *Netrc
+}
+
+// newAuthTransport returns transport, adding credentials set
+// in options to requests for the host.
+func newAuthTransport(base http.RoundTripper, host string, o AuthOptions) *authTransport {
+	t := &authTransport{
+		Base:    base,
+		Host:    host,
+		Headers: o.Headers,
+		Token:   o.Token,
+	}
+	if o.TokenFile != "" {
+		t.TokenFile = &TokenFile{Path: expandHome(o.TokenFile)}
+	}
+	if o.Netrc != "" {
+		t.Netrc = &Netrc{Path: expandHome(o.Netrc)}
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.URL.Host != t.Host {
+		return base.RoundTrip(req)
+	}
+
+	// RoundTripper shouldn't modify request
+	req = req.Clone(req.Context())
+	for name, values := range t.Headers {
+		req.Header[name] = values
+	}
+	if req.Header.Get("Authorization") == "" {
+		if err := t.authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	return base.RoundTrip(req)
+}
+
+// authorize sets Authorization header of the request.
+func (t *authTransport) authorize(req *http.Request) error {
+	token := t.Token
+	if t.TokenFile != nil {
+		var err error
+		if token, err = t.TokenFile.Token(); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	if t.Netrc != nil {
+		login, password, ok, err := t.Netrc.Lookup(req.URL.Hostname())
+		if err != nil {
+			return err
+		}
+		if ok {
+			req.SetBasicAuth(login, password)
+			return nil
+		}
+	}
+
+	if user, pass := getBasicAuthEnv(); user != "" && pass != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	return nil
+}
+
+// TokenFile is a bearer token, stored in the file. File is re-read
+// when it changes, so rotated tokens are picked up.
+type TokenFile struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	token   string
+}
+
+// Token returns the current token.
+func (f *TokenFile) Token() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %v", err)
+	}
+	if fi.ModTime().Equal(f.modTime) {
+		return f.token, nil
+	}
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return "", fmt.Errorf("token file: %v", err)
+	}
+	f.token, f.modTime = strings.TrimSpace(string(data)), fi.ModTime()
+	return f.token, nil
+}
+
+// NetrcEntry represents credentials for single machine in netrc file.
+type NetrcEntry struct {
+	Login    string
+	Password string
+}
+
+// Netrc is a .netrc-style credentials file. File is re-read
+// when it changes.
+type Netrc struct {
+	Path string
+
+	mu       sync.Mutex
+	modTime  time.Time
+	machines map[string]NetrcEntry
+}
+
+// Lookup returns credentials for the host, falling back
+// to "default" entry.
+func (n *Netrc) Lookup(host string) (string, string, bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	fi, err := os.Stat(n.Path)
+	if err != nil {
+		return "", "", false, fmt.Errorf("netrc: %v", err)
+	}
+	if !fi.ModTime().Equal(n.modTime) {
+		data, err := ioutil.ReadFile(n.Path)
+		if err != nil {
+			return "", "", false, fmt.Errorf("netrc: %v", err)
+		}
+		n.machines, n.modTime = ParseNetrc(data), fi.ModTime()
+	}
+
+	entry, ok := n.machines[host]
+	if !ok {
+		entry, ok = n.machines[""]
+	}
+	return entry.Login, entry.Password, ok, nil
+}
+
+// ParseNetrc parses netrc file contents into entries by machine
+// name. Default entry, if present, has empty name.
+func ParseNetrc(data []byte) map[string]NetrcEntry {
+	machines := make(map[string]NetrcEntry)
+	var (
+		name    string
+		entry   NetrcEntry
+		inEntry bool
+	)
+	flush := func() {
+		if inEntry {
+			if _, ok := machines[name]; !ok {
+				machines[name] = entry
+			}
+		}
+		name, entry, inEntry = "", NetrcEntry{}, false
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			if strings.HasPrefix(fields[j], "#") {
+				break
+			}
+			next := func() string {
+				if j+1 < len(fields) {
+					j++
+					return fields[j]
+				}
+				return ""
+			}
+			switch fields[j] {
+			case "machine":
+				flush()
+				name, inEntry = next(), true
+			case "default":
+				flush()
+				inEntry = true
+			case "login":
+				entry.Login = next()
+			case "password":
+				entry.Password = next()
+			case "account":
+				next()
+			case "macdef":
+				// macro definition lasts until empty line
+				flush()
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+	flush()
+	return machines
+}
+
+// sensitiveParams are query parameters, redacted in displayed URLs.
+var sensitiveParams = []string{"token", "access_token", "api_key", "apikey", "key", "password", "secret"}
+
+// RedactURL returns URL string for displaying and exporting, with
+// password and sensitive query parameters replaced by "xxxxx".
+// Commands of exec URLs are redacted by redactCommand.
+func RedactURL(u url.URL) string {
+	if command, ok := ExecCommand(u); ok {
+		return ExecScheme + ":" + redactCommand(command)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			for _, param := range sensitiveParams {
+				if strings.EqualFold(name, param) {
+					query.Set(name, "xxxxx")
+				}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactError redacts URL in errors, returned by HTTP client.
+func redactError(err error) error {
+	uerr, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+	if u, perr := url.Parse(uerr.URL); perr == nil {
+		uerr.URL = RedactURL(*u)
+	}
+	return uerr
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antonholmquist/jason"
+	"github.com/gizak/termui"
+)
+
+// Browser is an interactive tree view of all vars exposed by service,
+// which allows to add and remove monitored vars on the fly. Vars are
+// fetched in background, and redraw is notified once they're ready.
+//
+// Keys: up/down - move, right - expand, left - collapse, enter - toggle,
+// space - add or remove var, r - refresh, b/esc - close.
+type Browser struct {
+	Service *Service
+	Err     error
+	List    *termui.List
+	Loading bool
+
+	redraw   chan<- struct{}
+	results  chan browserResult
+	root     *BrowserNode
+	expanded map[string]bool
+	lines    []*BrowserNode
+	cursor   int
+	offset   int
+}
+
+// BrowserNode represents single node of vars tree.
+type BrowserNode struct {
+	Key      string
+	Name     VarName // var name with inferred kind modifier
+	Value    *jason.Value
+	Children []*BrowserNode
+	Depth    int
+}
+
+// browserResult is a result of background fetch.
+type browserResult struct {
+	expvar *Expvar
+	err    error
+}
+
+// NewBrowser creates browser for the service and starts fetching all
+// its vars. Redraw, if not nil, is notified when fetch is done.
+func NewBrowser(service *Service, redraw chan<- struct{}) *Browser {
+	b := &Browser{
+		Service:  service,
+		expanded: make(map[string]bool),
+		redraw:   redraw,
+		results:  make(chan browserResult, 1),
+		root:     &BrowserNode{Depth: -1},
+	}
+	b.List = termui.NewList()
+	b.List.Border = true
+	b.List.BorderLabelFg = termui.ColorCyan | termui.AttrBold
+	b.List.ItemFgColor = termui.ColorWhite
+	b.Refresh()
+	return b
+}
+
+// Refresh starts fetching fresh data from service in background,
+// unless fetch is already in progress. Services, which can't be
+// fetched out of polling, show data of the last poll instead.
+func (b *Browser) Refresh() {
+	if b.Loading {
+		return
+	}
+	source, ok := untracked(b.Service.Source)
+	if !ok {
+		// services are updated between handling of keys,
+		// in the same loop, so it's safe to read them here
+		b.show(b.Service.Expvar, b.Service.Err)
+		return
+	}
+	b.Loading = true
+
+	go func() {
+		expvar, err := source.Fetch(nil)
+		b.results <- browserResult{expvar, err}
+		notify(b.redraw)
+	}()
+}
+
+// receive applies result of the background fetch, if it's done.
+func (b *Browser) receive() {
+	var res browserResult
+	select {
+	case res = <-b.results:
+	default:
+		return
+	}
+	b.Loading = false
+
+	expvar := res.expvar
+	if res.err != nil {
+		// fallback to the last fetched data, if any
+		expvar = b.Service.Expvar
+	}
+	b.show(expvar, res.err)
+}
+
+// show rebuilds tree for the expvar data.
+func (b *Browser) show(expvar *Expvar, err error) {
+	b.Err = err
+	b.root = &BrowserNode{Depth: -1}
+	if expvar != nil {
+		b.root.Children = browserNodes(expvar.Object, nil, 0)
+	}
+	b.rebuild()
+}
+
+// browserNodes builds tree of nodes for JSON object, sorted by key.
+func browserNodes(obj *jason.Object, path []string, depth int) []*BrowserNode {
+	var nodes []*BrowserNode
+	for key, value := range obj.Map() {
+		keys := append(append([]string{}, path...), key)
+		node := &BrowserNode{
+			Key:   key,
+			Name:  browserVarName(keys, value),
+			Value: value,
+			Depth: depth,
+		}
+		if child, err := value.Object(); err == nil {
+			node.Children = browserNodes(child, keys, depth+1)
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Key < nodes[j].Key })
+	return nodes
+}
+
+// browserVarName returns var name for the path, with kind modifier
+// inferred from key and value.
+func browserVarName(path []string, value *jason.Value) VarName {
+	escaped := make([]string, len(path))
+	for i, key := range path {
+		escaped[i] = escapeVarKey(key)
+	}
+	name := strings.Join(escaped, ".")
+
+	switch inferKind(path[len(path)-1], value) {
+	case KindMemory:
+		name = "mem:" + name
+	case KindDuration:
+		name = "duration:" + name
+	case KindString:
+		name = "str:" + name
+	}
+	return VarName(name)
+}
+
+// varKeyEscaper escapes backslashes, dots and selector metacharacters.
+var varKeyEscaper = strings.NewReplacer(`\`, `\\`, `.`, `\.`, `[`, `\[`, `]`, `\]`, `*`, `\*`, `{`, `\{`, `}`, `\}`)
+
+// escapeVarKey escapes key, so it can be used as a part of dotted
+// var name, and matches itself only. Label matchers of Prometheus
+// series, like `http_requests_total{code="200"}`, are left as is.
+func escapeVarKey(key string) string {
+	var labels string
+	if i := strings.IndexByte(key, '{'); i > 0 && isMetricName(key[:i]) {
+		if _, rest, err := parseLabels(key[i:]); err == nil && rest == "" {
+			key, labels = key[:i], key[i:]
+		}
+	}
+	return varKeyEscaper.Replace(key) + labels
+}
+
+// isMetricName returns true if s is a valid Prometheus metric name.
+func isMetricName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isIdentStart(c) && c != ':' && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// inferKind guesses kind of the var by its key and value.
+func inferKind(key string, value *jason.Value) VarKind {
+	if _, err := value.String(); err == nil {
+		return KindString
+	}
+	if _, err := value.Object(); err == nil {
+		return KindDefault
+	}
+	if _, err := value.Boolean(); err == nil {
+		return KindDefault
+	}
+
+	switch {
+	case strings.HasSuffix(key, "Ns"),
+		strings.Contains(key, "Pause"),
+		strings.HasSuffix(key, "_seconds"),
+		strings.Contains(key, "Duration"),
+		strings.Contains(key, "Latency"):
+		return KindDuration
+	case strings.Contains(key, "Alloc") && key != "Mallocs",
+		strings.HasSuffix(key, "Sys"),
+		strings.HasSuffix(key, "Inuse"),
+		strings.HasSuffix(key, "Idle"),
+		strings.HasSuffix(key, "Released"),
+		strings.HasSuffix(key, "Bytes"),
+		strings.HasSuffix(key, "_bytes"),
+		key == "NextGC":
+		return KindMemory
+	}
+	return KindDefault
+}
+
+// rebuild recalculates list of visible nodes.
+func (b *Browser) rebuild() {
+	b.lines = b.lines[:0]
+	var walk func(nodes []*BrowserNode)
+	walk = func(nodes []*BrowserNode) {
+		for _, node := range nodes {
+			b.lines = append(b.lines, node)
+			if b.expanded[node.Name.Long()] {
+				walk(node.Children)
+			}
+		}
+	}
+	walk(b.root.Children)
+
+	if b.cursor >= len(b.lines) {
+		b.cursor = len(b.lines) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+}
+
+// Selected returns node under cursor, or nil if tree is empty.
+func (b *Browser) Selected() *BrowserNode {
+	if len(b.lines) == 0 {
+		return nil
+	}
+	return b.lines[b.cursor]
+}
+
+// HandleKey handles browser keys. It returns false, if browser
+// should be closed.
+func (b *Browser) HandleKey(key string, data *UIData) bool {
+	b.receive()
+	node := b.Selected()
+	switch key {
+	case "b", "<Escape>":
+		return false
+	case "<Up>", "k":
+		if b.cursor > 0 {
+			b.cursor--
+		}
+	case "<Down>", "j":
+		if b.cursor < len(b.lines)-1 {
+			b.cursor++
+		}
+	case "<Home>":
+		b.cursor = 0
+	case "<End>":
+		b.cursor = len(b.lines) - 1
+	case "<Right>", "l":
+		if node != nil && len(node.Children) > 0 {
+			b.expanded[node.Name.Long()] = true
+		}
+	case "<Enter>":
+		if node != nil && len(node.Children) > 0 {
+			b.expanded[node.Name.Long()] = !b.expanded[node.Name.Long()]
+		}
+	case "<Left>", "h":
+		if node == nil {
+			break
+		}
+		if b.expanded[node.Name.Long()] {
+			b.expanded[node.Name.Long()] = false
+			break
+		}
+		// move to parent
+		for i := b.cursor - 1; i >= 0; i-- {
+			if b.lines[i].Depth < node.Depth {
+				b.cursor = i
+				break
+			}
+		}
+	case "<Space>":
+		if node != nil && len(node.Children) == 0 {
+			ToggleVar(data, node.Name)
+		}
+	case "r":
+		b.Refresh()
+	}
+	b.rebuild()
+	return true
+}
+
+// ToggleVar adds var to monitored vars of all services, or removes it,
+// if it's already monitored. Last remaining var is never removed, and
+// data of vars used by alert rules is kept.
+func ToggleVar(data *UIData, name VarName) {
+	defer data.ExpandVars()
+
+	for i, v := range data.Patterns {
+		if v.Long() != name.Long() || v.Rate() != name.Rate() {
+			continue
+		}
+		if len(data.Patterns) == 1 {
+			return
+		}
+		data.Patterns = append(data.Patterns[:i:i], data.Patterns[i+1:]...)
+		if usedByAlerts(data, v) {
+			return
+		}
+		for _, service := range data.Services {
+			service.RemoveVar(v)
+		}
+		return
+	}
+
+	data.Patterns = append(data.Patterns, name)
+	for _, service := range data.Services {
+		service.AddVar(name)
+	}
+}
+
+// usedByAlerts returns true if var is used by any of alert rules.
+func usedByAlerts(data *UIData, name VarName) bool {
+	if data.Alerts == nil {
+		return false
+	}
+	for _, v := range data.Alerts.Vars() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// monitored returns monitored var matching the path, if any.
+func monitored(data UIData, name VarName) (VarName, bool) {
+	for _, v := range data.Vars {
+		if v.Long() == name.Long() {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Update updates browser list and its size to fill the area below
+// the given height.
+func (b *Browser) Update(data UIData, y int) {
+	b.receive()
+	name := b.Service.Name
+	if b.Loading {
+		name += ", loading..."
+	}
+	b.List.BorderLabel = fmt.Sprintf("Vars of %s (space - add/remove, arrows - navigate, r - refresh, b - close)", name)
+	b.List.Y = y
+	b.List.Width = termui.TermWidth()
+	b.List.Height = termui.TermHeight() - y
+
+	if b.Err != nil {
+		b.List.Items = []string{alertText(fmt.Sprintf("fetch failed: %v", b.Err))}
+		if len(b.lines) == 0 {
+			return
+		}
+	} else {
+		b.List.Items = nil
+	}
+
+	rows := b.List.Height - 2 - len(b.List.Items)
+	if rows < 1 {
+		rows = 1
+	}
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+rows {
+		b.offset = b.cursor - rows + 1
+	}
+
+	for i := b.offset; i < len(b.lines) && i < b.offset+rows; i++ {
+		b.List.Items = append(b.List.Items, b.line(data, i))
+	}
+}
+
+// line returns text for i-th visible node.
+func (b *Browser) line(data UIData, i int) string {
+	node := b.lines[i]
+	indent := strings.Repeat("  ", node.Depth)
+
+	var text string
+	switch {
+	case len(node.Children) > 0 && b.expanded[node.Name.Long()]:
+		text = fmt.Sprintf("%s▾ %s", indent, node.Key)
+	case len(node.Children) > 0:
+		text = fmt.Sprintf("%s▸ %s {%d}", indent, node.Key, len(node.Children))
+	default:
+		name := node.Name
+		mark := " "
+		if v, ok := monitored(data, name); ok {
+			name, mark = v, "*"
+		}
+		value := "N/A"
+		if v := guessValue(node.Value); v != nil {
+			value = name.Format(v)
+		}
+		if arr, err := node.Value.Array(); err == nil {
+			value = fmt.Sprintf("%s (avg of %d)", value, len(arr))
+		}
+		text = fmt.Sprintf("%s%s %s: %s  %s", indent, mark, node.Key, value, kindName(name.Kind()))
+	}
+
+	// escape markup brackets
+	text = strings.NewReplacer("[", "(", "]", ")").Replace(text)
+	if i == b.cursor {
+		return fmt.Sprintf("[%s](fg-black,bg-cyan)", text)
+	}
+	return text
+}
+
+// kindName returns human-readable name of the kind.
+func kindName(kind VarKind) string {
+	for mod, k := range kindModifiers {
+		if k == kind {
+			return mod
+		}
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const browserJSON = `{
+	"cmdline": ["./app"],
+	"memstats": {"HeapAlloc": 1024, "PauseTotalNs": 500, "NumGC": 3},
+	"bench.bleve": {"count": 10}
+}`
+
+type staticSource struct{ expvar *Expvar }
+
+func (s staticSource) Fetch([]VarName) (*Expvar, error) { return s.expvar, nil }
+
+func TestBrowser(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(browserJSON))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/debug/vars")
+
+	service := NewService(*u, []VarName{"memstats.NumGC"})
+	data := NewUIData([]VarName{"memstats.NumGC"})
+	data.Services = []*Service{service}
+
+	b := NewBrowser(service, data.Redraw)
+	if !b.Loading || len(b.lines) != 0 {
+		t.Fatalf("Expecting vars to be fetched in background")
+	}
+	<-data.Redraw
+	b.receive()
+	if b.Loading || len(b.lines) != 3 {
+		t.Fatalf("Expecting 3 top-level nodes, but got %d", len(b.lines))
+	}
+	if b.lines[0].Key != "bench.bleve" {
+		t.Fatalf("Expecting nodes to be sorted, but got %q first", b.lines[0].Key)
+	}
+
+	// expand bench.bleve and select count
+	b.HandleKey("<Right>", data)
+	b.HandleKey("<Down>", data)
+	node := b.Selected()
+	if node.Name != `bench\.bleve.count` {
+		t.Fatalf("Expecting escaped var name, but got %q", node.Name)
+	}
+	slice := node.Name.ToSlice()
+	if len(slice) != 2 || slice[0] != "bench.bleve" {
+		t.Fatalf("Expecting var name to point to the node, but got %v", slice)
+	}
+
+	b.HandleKey("<Space>", data)
+	if len(data.Vars) != 2 || data.Vars[1] != node.Name {
+		t.Fatalf("Expecting var to be added, but got %v", data.Vars)
+	}
+	if _, ok := service.stacks[node.Name]; !ok {
+		t.Fatalf("Expecting stack to be created for the added var")
+	}
+
+	b.HandleKey("<Space>", data)
+	if len(data.Vars) != 1 {
+		t.Fatalf("Expecting var to be removed, but got %v", data.Vars)
+	}
+	if _, ok := service.stacks[node.Name]; ok {
+		t.Fatalf("Expecting stack to be removed for the removed var")
+	}
+
+	// collapse back to parent
+	b.HandleKey("<Left>", data)
+	b.HandleKey("<Left>", data)
+	if len(b.lines) != 3 {
+		t.Fatalf("Expecting node to be collapsed, but got %d lines", len(b.lines))
+	}
+
+	if b.HandleKey("b", data) {
+		t.Fatalf("Expecting browser to be closed by 'b'")
+	}
+
+	// non-HTTP sources are not fetched, last polled data is shown
+	expvar, err := ParseExpvar(bytes.NewBufferString(browserJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service = NewService(url.URL{Scheme: FileScheme, Path: "/nonexistent"}, []VarName{"memstats.NumGC"})
+	service.Source = staticSource{}
+	service.update(expvar, nil, time.Now())
+	b = NewBrowser(service, data.Redraw)
+	if b.Loading || len(b.lines) != 3 {
+		t.Fatalf("Expecting last polled vars to be shown without fetching, but got %d lines", len(b.lines))
+	}
+}
+
+func TestToggleLastVar(t *testing.T) {
+	data := NewUIData([]VarName{"memstats.NumGC"})
+	ToggleVar(data, "memstats.NumGC")
+	if len(data.Vars) != 1 {
+		t.Fatalf("Expecting last var not to be removed")
+	}
+}
+
+func TestInferKind(t *testing.T) {
+	expvar, err := ParseExpvar(bytes.NewBufferString(browserJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path []string
+		want VarName
+	}{
+		{[]string{"memstats", "HeapAlloc"}, "mem:memstats.HeapAlloc"},
+		{[]string{"memstats", "PauseTotalNs"}, "duration:memstats.PauseTotalNs"},
+		{[]string{"memstats", "NumGC"}, "memstats.NumGC"},
+		{[]string{"cmdline"}, "cmdline"},
+	}
+	for _, test := range tests {
+		value, err := expvar.GetValue(test.path...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := browserVarName(test.path, value); name != test.want {
+			t.Fatalf("Expecting var name %q, but got %q", test.want, name)
+		}
+	}
+
+	if key := escapeVarKey(`http_requests_total{path="/a.b"}`); key != `http_requests_total{path="/a.b"}` {
+		t.Fatalf("Expecting labels not to be escaped, but got %q", key)
+	}
+}
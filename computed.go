@@ -0,0 +1,327 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ExprModifier is a modifier of computed vars, which values are
+// evaluated from arithmetic expression over other vars, like
+// "heap_util=expr:memstats.HeapInuse/memstats.HeapSys*100".
+//
+// Operands are var paths, optionally with array selectors, and
+// numbers; operators are + - * / and parens. Path characters,
+// which could be confused with operators, are escaped with "\".
+const ExprModifier = "expr"
+
+// computed is a parsed expression of computed var.
+type computed struct {
+	expr     valueExpr
+	operands []VarName
+}
+
+// computedVars caches parsed expressions of computed vars.
+var computedVars sync.Map
+
+// Computed returns true if var is computed from other vars ("expr:" modifier).
+func (v VarName) Computed() bool {
+	mods, _ := v.split()
+	return len(mods) > 0 && mods[len(mods)-1] == ExprModifier
+}
+
+// Operands returns vars, used in expression of computed var.
+func (v VarName) Operands() []VarName {
+	c, err := v.computed()
+	if err != nil {
+		return nil
+	}
+	return c.operands
+}
+
+// computed returns parsed expression of computed var.
+func (v VarName) computed() (*computed, error) {
+	_, s := v.split()
+	if c, ok := computedVars.Load(s); ok {
+		return c.(*computed), nil
+	}
+
+	c := &computed{}
+	p := &exprParser{s: s}
+	e, err := p.valueSum(c)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	c.expr = e
+
+	computedVars.Store(s, c)
+	return c, nil
+}
+
+// dataVars returns vars, which values are needed to get values of
+// the given vars, i.e. with computed vars replaced by their operands,
+// and counters of ring buffers.
+func dataVars(vars []VarName) []VarName {
+	ret := make([]VarName, 0, len(vars))
+	add := func(name VarName) {
+		ret = append(ret, name)
+		if counter, ok := name.ringCounter(); ok {
+			ret = append(ret, counter)
+		}
+	}
+	for _, name := range vars {
+		if name.Computed() {
+			for _, operand := range name.Operands() {
+				add(operand)
+			}
+			continue
+		}
+		add(name)
+	}
+	return ret
+}
+
+// computedValue evaluates computed var for the service. Missing or
+// non-numeric operands and division by zero result in nil value.
+func (s *Service) computedValue(expvar *Expvar, name VarName) VarValue {
+	c, err := name.computed()
+	if err != nil {
+		return nil
+	}
+	v, ok := c.expr.eval(func(operand VarName) (VarValue, bool) {
+		var v VarValue
+		if field, ok := operand.fetchVar(); ok {
+			v = s.fetchValue(field)
+		} else if value, err := varValue(expvar, operand); err == nil {
+			v = value
+		}
+		if _, ok := toFloat64(v); !ok {
+			return nil, false
+		}
+		return v, true
+	})
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// valueExpr is an arithmetic expression of computed var. Values are
+// int64, if all operands are integers, and float64 otherwise.
+type valueExpr interface {
+	eval(get func(VarName) (VarValue, bool)) (VarValue, bool)
+}
+
+type constValue struct{ v VarValue }
+
+func (e constValue) eval(func(VarName) (VarValue, bool)) (VarValue, bool) { return e.v, true }
+
+type operandValue VarName
+
+func (e operandValue) eval(get func(VarName) (VarValue, bool)) (VarValue, bool) {
+	return get(VarName(e))
+}
+
+type negValue struct{ x valueExpr }
+
+func (e negValue) eval(get func(VarName) (VarValue, bool)) (VarValue, bool) {
+	x, ok := e.x.eval(get)
+	if !ok {
+		return nil, false
+	}
+	if n, ok := x.(int64); ok {
+		return -n, true
+	}
+	f, _ := toFloat64(x)
+	return -f, true
+}
+
+type binaryValue struct {
+	op   byte
+	x, y valueExpr
+}
+
+func (e binaryValue) eval(get func(VarName) (VarValue, bool)) (VarValue, bool) {
+	x, ok := e.x.eval(get)
+	if !ok {
+		return nil, false
+	}
+	y, ok := e.y.eval(get)
+	if !ok {
+		return nil, false
+	}
+
+	// keep integers, unless divided
+	a, aInt := x.(int64)
+	b, bInt := y.(int64)
+	if aInt && bInt && e.op != '/' {
+		switch e.op {
+		case '+':
+			return a + b, true
+		case '-':
+			return a - b, true
+		case '*':
+			return a * b, true
+		}
+	}
+
+	fx, _ := toFloat64(x)
+	fy, _ := toFloat64(y)
+	var v float64
+	switch e.op {
+	case '+':
+		v = fx + fy
+	case '-':
+		v = fx - fy
+	case '*':
+		v = fx * fy
+	case '/':
+		if fy == 0 {
+			return nil, false
+		}
+		v = fx / fy
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, false
+	}
+	return v, true
+}
+
+// valueSum parses sum: product {(+|-) product}, collecting operands into c.
+func (p *exprParser) valueSum(c *computed) (valueExpr, error) {
+	x, err := p.valueProduct(c)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.valueProduct(c)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryValue{op, x, y}
+	}
+}
+
+// valueProduct parses product: operand {(*|/) operand}.
+func (p *exprParser) valueProduct(c *computed) (valueExpr, error) {
+	x, err := p.valueOperand(c)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.valueOperand(c)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryValue{op, x, y}
+	}
+}
+
+// valueOperand parses number, var path, negation or expression in parens.
+func (p *exprParser) valueOperand(c *computed) (valueExpr, error) {
+	p.skipSpace()
+	ch := p.peek()
+	switch {
+	case p.eof():
+		return nil, p.errorf("unexpected end of expression")
+	case ch == '-':
+		p.pos++
+		x, err := p.valueOperand(c)
+		return negValue{x}, err
+	case ch == '(':
+		p.pos++
+		x, err := p.valueSum(c)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return x, nil
+	case ch == '.' || ch >= '0' && ch <= '9':
+		start := p.pos
+		for !p.eof() && (strings.IndexByte("0123456789.", p.peek()) >= 0 ||
+			(p.peek() == 'e' || p.peek() == 'E') && p.pos+1 < len(p.s) && strings.IndexByte("0123456789", p.s[p.pos+1]) >= 0) {
+			p.pos++
+		}
+		s := p.s[start:p.pos]
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return constValue{n}, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", s)
+		}
+		return constValue{f}, nil
+	case isIdentStart(ch) || ch == '\\':
+		start := p.pos
+		name, err := p.varPath()
+		if err != nil {
+			return nil, err
+		}
+		if err := name.Validate(); err != nil {
+			p.pos = start
+			return nil, p.errorf("%v", err)
+		}
+		c.operands = appendVars(c.operands, name)
+		return operandValue(name), nil
+	}
+	return nil, p.errorf("unexpected %q", ch)
+}
+
+// varPath parses var path operand: keys, array selectors and label
+// matchers. Escapes, other than of dots and brackets, are removed,
+// so "http.requests.\/api" is "http.requests./api".
+func (p *exprParser) varPath() (VarName, error) {
+	var b strings.Builder
+	for !p.eof() {
+		ch := p.peek()
+		switch {
+		case ch == '\\':
+			if p.pos+1 >= len(p.s) {
+				return "", p.errorf("unexpected end of expression")
+			}
+			next := p.s[p.pos+1]
+			if strings.IndexByte(`.[]\`, next) >= 0 {
+				b.WriteByte(ch)
+			}
+			b.WriteByte(next)
+			p.pos += 2
+		case ch == '[' || ch == '{':
+			end := indexEnd(p.s[p.pos:])
+			if ch == '{' {
+				end = strings.IndexByte(p.s[p.pos:], '}')
+			}
+			if end < 0 {
+				return "", p.errorf("unclosed bracket")
+			}
+			b.WriteString(p.s[p.pos : p.pos+end+1])
+			p.pos += end + 1
+		case isIdentStart(ch) || ch >= '0' && ch <= '9' || ch == '.':
+			b.WriteByte(ch)
+			p.pos++
+		default:
+			return VarName(b.String()), nil
+		}
+	}
+	return VarName(b.String()), nil
+}
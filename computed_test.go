@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const computedJSON = `{
+	"cmdline": ["app"],
+	"memstats": {"PauseTotalNs": 30, "HeapInuse": 300, "HeapSys": 1200, "Mallocs": 1000, "Frees": 400, "Zero": 0, "PauseNs": [10, 20]},
+	"http": {"requests": {"/api": 50, "errors": 5}},
+	"name": "app"
+}`
+
+func TestComputedVar(t *testing.T) {
+	v := VarName("heap_util=expr:memstats.HeapInuse/memstats.HeapSys*100")
+	if !v.Computed() || v.Alias() != "heap_util" || v.Short() != "heap_util" {
+		t.Fatalf("Expecting named computed var, but got computed=%v, alias=%q, short=%q", v.Computed(), v.Alias(), v.Short())
+	}
+	if v.Long() != "memstats.HeapInuse/memstats.HeapSys*100" {
+		t.Fatalf("Expecting long name to be expression, but got %q", v.Long())
+	}
+	if want := []VarName{"memstats.HeapInuse", "memstats.HeapSys"}; !reflect.DeepEqual(v.Operands(), want) {
+		t.Fatalf("Expecting operands to be %v, but got %v", want, v.Operands())
+	}
+
+	v = VarName("live=mem:expr:(memstats.Mallocs - memstats.Frees) * 2")
+	if !v.Computed() || v.Kind() != KindMemory || v.Short() != "live" {
+		t.Fatalf("Expecting kind modifier before expr, but got kind %v, short %q", v.Kind(), v.Short())
+	}
+	if v := VarName("expr:a.b+c").Short(); v != "a.b+c" {
+		t.Fatalf("Expecting short name of unnamed computed var to be expression, but got %q", v)
+	}
+
+	rule, err := ParseAlertRule("errs=expr:http.errors+http.timeouts rate > 10/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Var != "errs=rate:expr:http.errors+http.timeouts" || !rule.Var.Rate() || !rule.Var.Computed() {
+		t.Fatalf("Expecting rate modifier to be added after alias, but got %s", rule.Var)
+	}
+
+	// "=" in paths and label matchers is not an alias
+	for _, name := range []VarName{`http_requests_total{code="500"}`, "memstats.BySize[?Size==8].Mallocs"} {
+		if name.Alias() != "" || name.Long() != string(name) {
+			t.Fatalf("Expecting %s not to have alias, but got %q", name, name.Alias())
+		}
+	}
+
+	for _, test := range []struct {
+		name VarName
+		err  string
+	}{
+		{"expr:", "unexpected end of expression"},
+		{"expr:a+", "unexpected end of expression at position 3"},
+		{"expr:(a+b", "expected )"},
+		{"expr:a b", `unexpected "b"`},
+		{"expr:a.PauseNs[+]", "invalid var"},
+		{"expr:a % b", `unexpected "% b" at position 3`},
+	} {
+		err := test.name.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("Expecting %s to fail with %q, but got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestServiceComputed(t *testing.T) {
+	vars := []VarName{
+		"heap_util=expr:memstats.HeapInuse/memstats.HeapSys*100",
+		"live=expr:memstats.Mallocs - memstats.Frees",
+		"expr:http.requests.errors / http.requests.\\/api",
+		"expr:memstats.PauseNs[-1] * 1e3 / 1000",
+		"zero=expr:memstats.Mallocs/memstats.Zero",
+		"missing=expr:memstats.Missing+1",
+		"str=expr:name+1",
+		"mem:expr:_fetch.failures+memstats.HeapSys",
+	}
+	for _, name := range vars {
+		if err := name.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service := NewService(url.URL{Host: "localhost:1234"}, vars)
+
+	// operands are extracted instead of computed vars
+	extracted, err := ExtractExpvar(strings.NewReader(computedJSON), service.vars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.update(extracted, nil, time.Now())
+
+	tests := []struct {
+		name VarName
+		want string
+	}{
+		{vars[0], "25.00"},
+		{vars[1], "600"},
+		{vars[2], "0.10"},
+		{vars[3], "20.00"},
+		{vars[4], "N/A"},
+		{vars[5], "N/A"},
+		{vars[6], "N/A"},
+		{vars[7], "1.2KB"},
+	}
+	for _, test := range tests {
+		if got := service.Value(test.name); got != test.want {
+			t.Fatalf("Expecting %s to be %q, but got %q", test.name, test.want, got)
+		}
+	}
+	if max := service.Max(vars[1]); max != "600" {
+		t.Fatalf("Expecting computed var to track max, but got %v", max)
+	}
+}
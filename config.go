@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config represents expvarmon config file.
+//
+// Config file is a JSON, YAML or TOML document, describing services
+// to monitor, vars, polling interval and UI mode. Command line flags,
+// if set, override values from the config file.
+type Config struct {
+	Interval Duration        `json:"interval"`
+	Endpoint string          `json:"endpoint"`
+	UI       string          `json:"ui"`
+	Serve    string          `json:"serve"`
+	Vars     []string        `json:"vars"`
+	Services []ServiceConfig `json:"services"`
+
+	Alerts       []string `json:"alerts"`
+	AlertCommand string   `json:"alert_command"`
+	AlertWebhook string   `json:"alert_webhook"`
+
+	Discover         []string `json:"discover"`
+	DiscoverInterval Duration `json:"discover_interval"`
+
+	ProfileDir     string `json:"profile_dir"`
+	ProfileSeconds int    `json:"profile_seconds"`
+	Goroutines     int    `json:"goroutines"`
+
+	FetchConfig
+}
+
+// ServiceConfig represents single service entry in config file.
+//
+// URL has the same format as -ports flag entries, so it can
+// be port, range of ports or full URL. Type is either "expvar"
+// (default), "prometheus" or "exec", which runs Command instead.
+type ServiceConfig struct {
+	URL      string   `json:"url"`
+	Type     string   `json:"type"`
+	Command  string   `json:"command"`
+	Name     string   `json:"name"`
+	Endpoint string   `json:"endpoint"`
+	User     string   `json:"user"`
+	Password string   `json:"password"`
+	Vars     []string `json:"vars"`
+
+	FetchConfig
+}
+
+// FetchConfig represents fetch options, which can be set
+// globally or per service. Numeric options are pointers, so
+// zero values (like "retries": 0) override non-zero defaults.
+type FetchConfig struct {
+	Timeout *Duration `json:"timeout"`
+	Retries *int      `json:"retries"`
+	Backoff *Duration `json:"backoff"`
+	Slow    *Duration `json:"slow"`
+
+	TLSCA         string `json:"tls_ca"`
+	TLSCert       string `json:"tls_cert"`
+	TLSKey        string `json:"tls_key"`
+	TLSServerName string `json:"tls_server_name"`
+	TLSInsecure   bool   `json:"tls_insecure"`
+
+	Token     string            `json:"token"`
+	TokenFile string            `json:"token_file"`
+	Netrc     string            `json:"netrc"`
+	Headers   map[string]string `json:"headers"`
+}
+
+// apply returns fetch options with values, set in config,
+// overriding the given ones.
+func (fc FetchConfig) apply(o FetchOptions) FetchOptions {
+	if fc.Timeout != nil {
+		o.Timeout = time.Duration(*fc.Timeout)
+	}
+	if fc.Retries != nil {
+		o.Retries = *fc.Retries
+	}
+	if fc.Backoff != nil {
+		o.MaxBackoff = time.Duration(*fc.Backoff)
+	}
+	if fc.Slow != nil {
+		o.Slow = time.Duration(*fc.Slow)
+	}
+	o.TLS = o.TLS.Merge(TLSOptions{
+		CAFile:     fc.TLSCA,
+		CertFile:   fc.TLSCert,
+		KeyFile:    fc.TLSKey,
+		ServerName: fc.TLSServerName,
+		Insecure:   fc.TLSInsecure,
+	})
+	o.Auth = o.Auth.Merge(AuthOptions{
+		Token:     fc.Token,
+		TokenFile: fc.TokenFile,
+		Netrc:     fc.Netrc,
+		Headers:   fc.headers(),
+	})
+	return o
+}
+
+// headers returns extra headers, set in config.
+func (fc FetchConfig) headers() http.Header {
+	if len(fc.Headers) == 0 {
+		return nil
+	}
+	headers := make(http.Header)
+	for name, value := range fc.Headers {
+		headers.Set(name, value)
+	}
+	return headers
+}
+
+// Duration is a time.Duration, represented in config file
+// as a string, like "5s" or "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// UI modes, supported in config file.
+var configUIModes = map[string]bool{
+	"":         true,
+	"multi":    true,
+	"single":   true,
+	"dummy":    true,
+	"headless": true,
+}
+
+// ConfigError represents single problem found in config file.
+type ConfigError struct {
+	Line  int
+	Field string
+	Msg   string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Msg)
+}
+
+// ConfigErrors holds all problems found in config file.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LoadConfig reads and validates config file. Format of the file
+// is chosen by extension: .yaml, .yml, .toml or JSON otherwise.
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err = configToJSON(filename, data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parses and validates config file contents.
+//
+// All invalid entries are reported at once, with their line numbers.
+func ParseConfig(data []byte) (*Config, error) {
+	c := &configChecker{
+		data:  data,
+		dec:   json.NewDecoder(bytes.NewReader(data)),
+		lines: make(map[string]int),
+	}
+	if err := c.check(reflect.TypeOf(Config{}), ""); err != nil {
+		return nil, ConfigErrors{{
+			Line:  c.line(c.dec.InputOffset()),
+			Field: "config",
+			Msg:   err.Error(),
+		}}
+	}
+	if len(c.errs) > 0 {
+		return nil, c.errs
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if errs := cfg.validate(c.lines); len(errs) > 0 {
+		return nil, errs
+	}
+	return &cfg, nil
+}
+
+// validate checks values of the parsed config, using lines
+// to find line numbers for the invalid fields.
+func (cfg *Config) validate(lines map[string]int) ConfigErrors {
+	var errs ConfigErrors
+	report := func(field, format string, args ...interface{}) {
+		errs = append(errs, ConfigError{
+			Line:  lines[field],
+			Field: field,
+			Msg:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	if cfg.Interval < 0 {
+		report("interval", "interval should be positive")
+	}
+	if cfg.DiscoverInterval < 0 {
+		report("discover_interval", "discovery interval should be positive")
+	}
+	if cfg.ProfileSeconds < 0 {
+		report("profile_seconds", "CPU profile duration should be positive")
+	}
+	if cfg.Goroutines < 0 {
+		report("goroutines", "number of goroutine stacks should be positive")
+	}
+	for i, d := range cfg.Discover {
+		if _, err := parseDiscoverer(d); err != nil {
+			report(fmt.Sprintf("discover[%d]", i), "%v", err)
+		}
+	}
+	if !configUIModes[cfg.UI] {
+		report("ui", "unknown UI mode %q, should be one of multi, single, dummy or headless", cfg.UI)
+	}
+	validateVars := func(field string, vars []string) {
+		for i, v := range vars {
+			if strings.TrimSpace(v) == "" {
+				report(fmt.Sprintf("%s[%d]", field, i), "empty var name")
+			} else if err := VarName(v).Validate(); err != nil {
+				report(fmt.Sprintf("%s[%d]", field, i), "%v", err)
+			}
+		}
+	}
+	validateFetch := func(prefix string, fc FetchConfig) {
+		if fc.Timeout != nil && *fc.Timeout < 0 {
+			report(prefix+"timeout", "timeout should be positive")
+		}
+		if fc.Retries != nil && *fc.Retries < 0 {
+			report(prefix+"retries", "number of retries should be positive")
+		}
+		if fc.Backoff != nil && *fc.Backoff < 0 {
+			report(prefix+"backoff", "backoff should be positive")
+		}
+		if fc.Slow != nil && *fc.Slow < 0 {
+			report(prefix+"slow", "slow threshold should be positive")
+		}
+		if _, err := fc.apply(FetchOptions{}).TLS.Config(); err != nil {
+			report(prefix+"tls", "%v", err)
+		}
+		if fc.Token != "" && fc.TokenFile != "" {
+			report(prefix+"token", "token and token_file can't be used together")
+		}
+		for name, value := range fc.Headers {
+			if _, _, err := ParseHeader(name + ": " + value); err != nil {
+				report(prefix+"headers", "%v", err)
+			}
+		}
+	}
+	validateFetch("", cfg.FetchConfig)
+	validateVars("vars", cfg.Vars)
+	for i, alert := range cfg.Alerts {
+		if _, err := ParseAlertRule(alert); err != nil {
+			report(fmt.Sprintf("alerts[%d]", i), "%v", err)
+		}
+	}
+
+	for i, sc := range cfg.Services {
+		field := fmt.Sprintf("services[%d]", i)
+		if sc.Type == "exec" {
+			if sc.Command == "" {
+				report(field, "command is not specified")
+			}
+		} else if sc.URL == "" {
+			report(field, "url is not specified")
+		} else if _, err := sc.urls(); err != nil {
+			report(field+".url", "%v: %q", err, sc.URL)
+		}
+		if sc.Type != "" && sc.Type != "expvar" && sc.Type != "prometheus" && sc.Type != "exec" {
+			report(field+".type", "unknown service type %q, should be expvar, prometheus or exec", sc.Type)
+		}
+		validateVars(field+".vars", sc.Vars)
+		validateFetch(field+".", sc.FetchConfig)
+	}
+	return errs
+}
+
+// NewServices creates services declared in config file. Each service
+// monitors global vars and its own vars, if specified.
+func (cfg *Config) NewServices(vars []VarName) ([]*Service, error) {
+	var services []*Service
+	for _, sc := range cfg.Services {
+		urls, err := sc.urls()
+		if err != nil {
+			return nil, err
+		}
+
+		svcVars := appendVars(vars, varNames(sc.Vars)...)
+		for _, u := range urls {
+			if sc.Endpoint != "" {
+				if socket, _, ok := UnixSocket(u); ok {
+					u.Path = UnixURL(socket, sc.Endpoint).Path
+				} else {
+					u.Path = sc.Endpoint
+				}
+			}
+			if sc.User != "" {
+				u.User = url.UserPassword(sc.User, sc.Password)
+			}
+
+			service := NewService(u, svcVars)
+			if !reflect.DeepEqual(sc.FetchConfig, FetchConfig{}) {
+				service.SetOptions(sc.apply(DefaultFetchOptions))
+			}
+			if sc.Name != "" {
+				service.Name = sc.Name
+				service.fixedName = true
+			}
+			services = append(services, service)
+		}
+	}
+	return services, nil
+}
+
+// AlertRules returns parsed alert rules, declared in config file.
+func (cfg *Config) AlertRules() []*AlertRule {
+	var rules []*AlertRule
+	for _, alert := range cfg.Alerts {
+		// rules are already validated
+		rule, _ := ParseAlertRule(alert)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// urls returns URLs of the service entry.
+func (sc ServiceConfig) urls() ([]url.URL, error) {
+	if sc.Type == "exec" {
+		return []url.URL{ExecURL(sc.Command)}, nil
+	}
+	s := sc.URL
+	if sc.Type == "prometheus" && !strings.HasPrefix(s, PrometheusSchemePrefix) {
+		s = PrometheusSchemePrefix + s
+	}
+	return ParsePorts(s)
+}
+
+// ServiceVars returns all service specific vars, declared in config file.
+func (cfg *Config) ServiceVars() []VarName {
+	var vars []VarName
+	for _, sc := range cfg.Services {
+		vars = appendVars(vars, varNames(sc.Vars)...)
+	}
+	return vars
+}
+
+// varNames converts strings to the slice of VarName.
+func varNames(ss []string) []VarName {
+	var vars []VarName
+	for _, s := range ss {
+		vars = append(vars, VarName(s))
+	}
+	return vars
+}
+
+// appendVars returns new slice with vars appended, skipping duplicates.
+func appendVars(vars []VarName, more ...VarName) []VarName {
+	ret := append([]VarName{}, vars...)
+	for _, v := range more {
+		found := false
+		for _, existing := range ret {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// configChecker walks over config JSON tokens, checking them against
+// Config struct and collecting all found problems along with line numbers.
+type configChecker struct {
+	data  []byte
+	dec   *json.Decoder
+	lines map[string]int
+	errs  ConfigErrors
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// check reads next JSON value and checks if it's valid for type t.
+//
+// Nil type means unknown field, and value is just skipped. Returned
+// error means that JSON itself is malformed and checking can't continue.
+func (c *configChecker) check(t reflect.Type, path string) error {
+	line := c.line(c.dec.InputOffset())
+	c.lines[path] = line
+	tok, err := c.dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("unexpected end of file")
+		}
+		return err
+	}
+
+	report := func(format string, args ...interface{}) {
+		field := path
+		if field == "" {
+			field = "config"
+		}
+		c.errs = append(c.errs, ConfigError{
+			Line:  line,
+			Field: field,
+			Msg:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	if t != nil && reflect.PtrTo(t).Implements(unmarshalerType) {
+		v := reflect.New(t).Interface().(json.Unmarshaler)
+		b, _ := json.Marshal(tok)
+		if err := v.UnmarshalJSON(b); err != nil {
+			report("invalid value %v: %v", tokenString(tok), err)
+		}
+		return c.skip(tok)
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		if t != nil && t.Kind() != reflect.Struct && t.Kind() != reflect.Map {
+			report("expected %s, got object", typeName(t))
+			t = nil
+		}
+		for c.dec.More() {
+			keyTok, err := c.dec.Token()
+			if err != nil {
+				return err
+			}
+			key := keyTok.(string)
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+
+			var ft reflect.Type
+			if t != nil && t.Kind() == reflect.Map {
+				ft = t.Elem()
+			} else if t != nil {
+				ft = fieldByTag(t, key)
+				if ft == nil {
+					c.errs = append(c.errs, ConfigError{
+						Line:  c.line(c.dec.InputOffset()),
+						Field: fieldPath,
+						Msg:   "unknown field",
+					})
+				}
+			}
+			if err := c.check(ft, fieldPath); err != nil {
+				return err
+			}
+		}
+		_, err := c.dec.Token()
+		return err
+	case json.Delim('['):
+		if t != nil && t.Kind() != reflect.Slice {
+			report("expected %s, got array", typeName(t))
+			t = nil
+		}
+		for i := 0; c.dec.More(); i++ {
+			var et reflect.Type
+			if t != nil {
+				et = t.Elem()
+			}
+			if err := c.check(et, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err := c.dec.Token()
+		return err
+	}
+
+	if t == nil || tok == nil {
+		return nil
+	}
+
+	var ok bool
+	switch tok.(type) {
+	case string:
+		ok = t.Kind() == reflect.String
+	case bool:
+		ok = t.Kind() == reflect.Bool
+	case float64:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			ok = true
+		}
+	}
+	if !ok {
+		report("expected %s, got %s", typeName(t), tokenString(tok))
+	}
+	return nil
+}
+
+// skip skips the rest of the value, if tok starts object or array.
+func (c *configChecker) skip(tok json.Token) error {
+	if tok != json.Delim('{') && tok != json.Delim('[') {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := c.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// line returns line number for the next token, starting from offset.
+func (c *configChecker) line(offset int64) int {
+	for int(offset) < len(c.data) && strings.IndexByte(" \t\r\n,:", c.data[offset]) != -1 {
+		offset++
+	}
+	return bytes.Count(c.data[:offset], []byte("\n")) + 1
+}
+
+// fieldByTag returns type of struct field with the given json tag.
+func fieldByTag(t reflect.Type, key string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		// fields of embedded structs are promoted
+		if f.Anonymous && f.Tag.Get("json") == "" && f.Type.Kind() == reflect.Struct {
+			if ft := fieldByTag(f.Type, key); ft != nil {
+				return ft
+			}
+			continue
+		}
+		if strings.Split(f.Tag.Get("json"), ",")[0] == key {
+			// optional values are pointers
+			if f.Type.Kind() == reflect.Ptr {
+				return f.Type.Elem()
+			}
+			return f.Type
+		}
+	}
+	return nil
+}
+
+// typeName returns human-readable name for the JSON type
+// expected for Go type t.
+func typeName(t reflect.Type) string {
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice:
+		return "array"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	}
+	return t.Kind().String()
+}
+
+// tokenString returns token representation for error messages.
+func tokenString(tok json.Token) string {
+	switch v := tok.(type) {
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case json.Delim:
+		if v == '{' {
+			return "object"
+		}
+		return "array"
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	}
+	return "null"
+}
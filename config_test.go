@@ -0,0 +1,265 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+	"interval": "2s",
+	"vars": ["mem:memstats.Alloc", "Goroutines"],
+	"services": [
+		{"url": "1234-1235", "name": "api"},
+		{
+			"url": "https://example.com:443",
+			"endpoint": "/_vars",
+			"user": "user",
+			"password": "pass",
+			"vars": ["rate:requests"],
+			"timeout": "10s",
+			"retries": 2,
+			"tls_server_name": "app.internal",
+			"headers": {"x-api-key": "key"}
+		}
+	]
+}`
+
+func TestConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Duration(cfg.Interval) != 2*time.Second {
+		t.Fatalf("Expecting interval to be 2s, but got %v", time.Duration(cfg.Interval))
+	}
+
+	vars, _ := ParseVars("mem:memstats.Alloc,Goroutines")
+	services, err := cfg.NewServices(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 3 {
+		t.Fatalf("Expecting 3 services, but got %d", len(services))
+	}
+	if services[1].Name != "api" || services[1].URL.Host != "localhost:1235" {
+		t.Fatalf("Service created incorrectly: %v, %v", services[1].Name, services[1].URL)
+	}
+	svc := services[2]
+	if svc.URL.Path != "/_vars" || svc.URL.User.Username() != "user" {
+		t.Fatalf("Service created incorrectly: %v", svc.URL)
+	}
+	if _, ok := svc.stacks["rate:requests"]; !ok || len(svc.stacks) != 3 {
+		t.Fatalf("Expecting service to have 3 vars, but got %d", len(svc.stacks))
+	}
+	if svc.Options.Timeout != 10*time.Second || svc.Options.Retries != 2 || svc.Options.MaxBackoff != time.Minute {
+		t.Fatalf("Expecting service fetch options to be set, but got %+v", svc.Options)
+	}
+	if svc.Options.TLS.ServerName != "app.internal" {
+		t.Fatalf("Expecting service TLS options to be set, but got %+v", svc.Options.TLS)
+	}
+	if svc.Options.Auth.Headers.Get("X-Api-Key") != "key" {
+		t.Fatalf("Expecting service headers to be set, but got %+v", svc.Options.Auth)
+	}
+	if !reflect.DeepEqual(services[0].Options, DefaultFetchOptions) {
+		t.Fatalf("Expecting default fetch options, but got %+v", services[0].Options)
+	}
+
+	all := appendVars(vars, cfg.ServiceVars()...)
+	if len(all) != 3 || all[2] != "rate:requests" {
+		t.Fatalf("Expecting service vars to be appended, but got %v", all)
+	}
+
+	// zero values override global options
+	defaults := DefaultFetchOptions
+	defer func() { DefaultFetchOptions = defaults }()
+	DefaultFetchOptions.Retries, DefaultFetchOptions.Slow = 3, time.Second
+	cfg, err = ParseConfig([]byte(`{"services": [{"url": "1234", "retries": 0, "slow": "0s"}, {"url": "1235", "timeout": "5s"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	services, err = cfg.NewServices(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := services[0].Options; o.Retries != 0 || o.Slow != 0 {
+		t.Fatalf("Expecting retries and slow to be overridden with zeros, but got %+v", o)
+	}
+	if o := services[1].Options; o.Retries != 3 || o.Slow != time.Second || o.Timeout != 5*time.Second {
+		t.Fatalf("Expecting unset options to be kept, but got %+v", o)
+	}
+}
+
+const testConfigInvalid = `{
+	"interval": "2 seconds",
+	"ui": "fancy",
+	"services": [
+		{"url": "1234", "nmae": "api"},
+		{"name": "no url"},
+		{"url": "some:weird:1234-123input"},
+		{"url": 1234}
+	]
+}`
+
+func TestConfigInvalid(t *testing.T) {
+	_, err := ParseConfig([]byte(testConfigInvalid))
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("Expecting ConfigErrors, but got %v", err)
+	}
+
+	// type errors are reported first
+	want := []ConfigError{
+		{Line: 2, Field: "interval"},
+		{Line: 5, Field: "services[0].nmae"},
+		{Line: 8, Field: "services[3].url"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expecting %d errors, but got %d: %v", len(want), len(errs), errs)
+	}
+	for i, w := range want {
+		if errs[i].Line != w.Line || errs[i].Field != w.Field {
+			t.Fatalf("Expecting error %d to be at line %d for %s, but got %v", i, w.Line, w.Field, errs[i])
+		}
+	}
+
+	// fix type errors to get validation errors
+	_, err = ParseConfig([]byte(`{
+	"ui": "fancy",
+	"services": [
+		{"name": "no url"},
+		{"url": "some:weird:1234-123input"},
+		{"url": "https://app:443", "tls_cert": "client.pem"}
+	]
+}`))
+	errs, ok = err.(ConfigErrors)
+	if !ok || len(errs) != 4 {
+		t.Fatalf("Expecting 4 errors, but got %v", err)
+	}
+	if errs[0].Line != 2 || errs[1].Line != 4 || errs[2].Line != 5 || errs[3].Field != "services[2].tls" {
+		t.Fatalf("Errors reported for wrong lines: %v", errs)
+	}
+}
+
+const testConfigYAML = `interval: 2s
+vars: [mem:memstats.Alloc, Goroutines]
+services:
+  - url: 1234-1235
+    name: api
+  - url: https://example.com:443
+    endpoint: /_vars
+    user: user
+    password: pass
+    vars:
+      - rate:requests
+    timeout: 10s
+    retries: 2
+    tls_server_name: app.internal
+    headers: {x-api-key: key}
+`
+
+const testConfigTOML = `interval = "2s"
+vars = ["mem:memstats.Alloc", "Goroutines"]
+
+[[services]]
+url = "1234-1235"
+name = "api"
+
+[[services]]
+url = "https://example.com:443"
+endpoint = "/_vars"
+user = "user"
+password = "pass"
+vars = [
+	"rate:requests",
+]
+timeout = "10s"
+retries = 2
+tls_server_name = "app.internal"
+headers = {x-api-key = "key"}
+`
+
+func TestConfigFormats(t *testing.T) {
+	want, err := ParseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filename, data := range map[string]string{
+		"config.yaml": testConfigYAML,
+		"config.yml":  testConfigYAML,
+		"config.toml": testConfigTOML,
+	} {
+		converted, err := configToJSON(filename, []byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", filename, err)
+		}
+		cfg, err := ParseConfig(converted)
+		if err != nil {
+			t.Fatalf("%s: %v", filename, err)
+		}
+		if !reflect.DeepEqual(cfg, want) {
+			t.Fatalf("%s: expecting %+v, but got %+v", filename, want, cfg)
+		}
+	}
+
+	// errors are reported with lines of the original file
+	tests := []struct {
+		filename string
+		data     string
+		want     []ConfigError
+	}{
+		{"config.yaml", `interval: 2 seconds
+ui: multi
+services:
+  - url: "1234"
+    nmae: api
+  - url: 1234
+vars:
+  - mem:memstats.Alloc
+`, []ConfigError{
+			{Line: 1, Field: "interval"},
+			{Line: 5, Field: "services[0].nmae"},
+			{Line: 6, Field: "services[1].url"},
+		}},
+		{"config.toml", `interval = "2 seconds"
+ui = "multi"
+vars = [
+	"mem:memstats.Alloc",
+	"Goroutines",
+]
+
+[[services]]
+url = "1234"
+nmae = "api"
+
+[[services]]
+url = 1234
+`, []ConfigError{
+			{Line: 1, Field: "interval"},
+			{Line: 10, Field: "services[0].nmae"},
+			{Line: 13, Field: "services[1].url"},
+		}},
+		{"config.yaml", "services:\n  - url: 1234\n ui: multi\n", []ConfigError{
+			{Line: 2, Field: "config"},
+		}},
+		{"config.toml", "interval = \"2s\"\nui = \n", []ConfigError{
+			{Line: 2, Field: "config"},
+		}},
+	}
+	for _, test := range tests {
+		data, err := configToJSON(test.filename, []byte(test.data))
+		if err == nil {
+			_, err = ParseConfig(data)
+		}
+		errs, ok := err.(ConfigErrors)
+		if !ok || len(errs) != len(test.want) {
+			t.Fatalf("%s: expecting %d errors, but got %v", test.filename, len(test.want), err)
+		}
+		for i, w := range test.want {
+			if errs[i].Line != w.Line || errs[i].Field != w.Field {
+				t.Fatalf("%s: expecting error %d to be at line %d for %s, but got %v", test.filename, i, w.Line, w.Field, errs[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configFormats maps config file extensions to converters into JSON.
+// JSON is used for all other extensions.
+//
+// Converted JSON keeps values on the same lines as in the original
+// file, so config errors are reported with the original line numbers.
+var configFormats = map[string]func([]byte) ([]byte, error){
+	".yaml": yamlToJSON,
+	".yml":  yamlToJSON,
+	".toml": tomlToJSON,
+}
+
+// configToJSON converts config file contents into JSON, based on
+// the file extension.
+func configToJSON(filename string, data []byte) ([]byte, error) {
+	convert, ok := configFormats[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return data, nil
+	}
+	return convert(data)
+}
+
+// alignedWriter writes JSON, padding it with newlines to put
+// values on the given lines.
+type alignedWriter struct {
+	bytes.Buffer
+	line int
+}
+
+// pad moves writer to the line, if it's not passed yet.
+func (w *alignedWriter) pad(line int) {
+	for ; w.line < line; w.line++ {
+		w.WriteByte('\n')
+	}
+}
+
+// value writes JSON representation of the scalar value.
+func (w *alignedWriter) value(v interface{}) error {
+	if t, ok := v.(time.Time); ok {
+		v = t.Format(time.RFC3339Nano)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Write(b)
+	return nil
+}
+
+// yamlToJSON converts YAML document into JSON.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, configSyntaxError(err.Error(), yamlErrorLine(err))
+	}
+	w := &alignedWriter{line: 1}
+	if len(doc.Content) == 0 {
+		w.WriteString("{}")
+		return w.Bytes(), nil
+	}
+	if err := w.yaml(doc.Content[0]); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (w *alignedWriter) yaml(n *yaml.Node) error {
+	if n.Kind == yaml.AliasNode {
+		return w.yaml(n.Alias)
+	}
+	w.pad(n.Line)
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		w.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			key := n.Content[i]
+			w.pad(key.Line)
+			if err := w.value(key.Value); err != nil {
+				return err
+			}
+			w.WriteByte(':')
+			if err := w.yaml(n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('}')
+	case yaml.SequenceNode:
+		w.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			if err := w.yaml(item); err != nil {
+				return err
+			}
+		}
+		w.WriteByte(']')
+	default:
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return configSyntaxError(err.Error(), n.Line)
+		}
+		return w.value(v)
+	}
+	return nil
+}
+
+// yamlErrorLine extracts line number from YAML error, like
+// "yaml: line 3: mapping values are not allowed in this context".
+func yamlErrorLine(err error) int {
+	s := strings.TrimPrefix(err.Error(), "yaml: ")
+	if !strings.HasPrefix(s, "line ") {
+		return 0
+	}
+	s = s[len("line "):]
+	if i := strings.IndexByte(s, ':'); i > 0 {
+		s = s[:i]
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// tomlToJSON converts TOML document into JSON.
+func tomlToJSON(data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		if perr, ok := err.(toml.ParseError); ok {
+			return nil, configSyntaxError(perr.Message, perr.Line)
+		}
+		return nil, configSyntaxError(err.Error(), 0)
+	}
+	w := &alignedWriter{line: 1}
+	if err := w.toml(doc, "", tomlLines(string(data))); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (w *alignedWriter) toml(v interface{}, path string, lines map[string]int) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// keys are written in the order of the file
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			li, lj := lines[joinPath(path, keys[i])], lines[joinPath(path, keys[j])]
+			if li != lj {
+				return li < lj
+			}
+			return keys[i] < keys[j]
+		})
+
+		w.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.pad(lines[joinPath(path, key)])
+			if err := w.value(key); err != nil {
+				return err
+			}
+			w.WriteByte(':')
+			if err := w.toml(v[key], joinPath(path, key), lines); err != nil {
+				return err
+			}
+		}
+		w.WriteByte('}')
+	case []map[string]interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return w.toml(items, path, lines)
+	case []interface{}:
+		w.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			w.pad(lines[itemPath])
+			if err := w.toml(item, itemPath, lines); err != nil {
+				return err
+			}
+		}
+		w.WriteByte(']')
+	default:
+		return w.value(v)
+	}
+	return nil
+}
+
+// joinPath returns path of the key in object at path, in the
+// same format as config errors use, like "services[0].url".
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// tomlLines returns line numbers of keys, tables and array elements
+// in TOML document, with paths in the format of joinPath. Document
+// is expected to be valid.
+func tomlLines(s string) map[string]int {
+	lines := make(map[string]int)
+	tables := make(map[string]int) // number of elements in arrays of tables
+	table := ""
+
+	line := 1
+	i := 0
+	for i < len(s) {
+		// start of line: skip whitespace
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		switch {
+		case i >= len(s):
+		case s[i] == '\n' || s[i] == '\r' || s[i] == '#':
+		case strings.HasPrefix(s[i:], "[["):
+			end := strings.Index(s[i:], "]]")
+			if end < 0 {
+				return lines
+			}
+			name := strings.Join(tomlKey(s[i+2:i+end]), ".")
+			n := tables[name]
+			tables[name]++
+			table = fmt.Sprintf("%s[%d]", name, n)
+			lines[table] = line
+			if n == 0 {
+				lines[name] = line
+			}
+			i += end + 2
+		case s[i] == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return lines
+			}
+			table = strings.Join(tomlKey(s[i+1:i+end]), ".")
+			lines[table] = line
+			i += end + 1
+		default:
+			eq := strings.IndexByte(s[i:], '=')
+			if eq < 0 {
+				return lines
+			}
+			path := table
+			for _, key := range tomlKey(s[i : i+eq]) {
+				path = joinPath(path, key)
+			}
+			lines[path] = line
+			i += eq + 1
+			i, line = tomlValueLines(s, i, line, path, lines)
+		}
+		// skip the rest of line
+		for i < len(s) && s[i] != '\n' {
+			i++
+		}
+		if i < len(s) {
+			i++
+			line++
+		}
+	}
+	return lines
+}
+
+// tomlValueLines skips value starting at i, recording lines of
+// array elements, and returns position after the value and its line.
+func tomlValueLines(s string, i, line int, path string, lines map[string]int) (int, int) {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	if i >= len(s) {
+		return i, line
+	}
+	switch {
+	case strings.HasPrefix(s[i:], `"""`), strings.HasPrefix(s[i:], `'''`):
+		quote := s[i : i+3]
+		end := strings.Index(s[i+3:], quote)
+		if end < 0 {
+			return len(s), line
+		}
+		line += strings.Count(s[i:i+3+end], "\n")
+		return i + 3 + end + 3, line
+	case s[i] == '"' || s[i] == '\'':
+		j := i + 1
+		for j < len(s) && s[j] != s[i] && s[j] != '\n' {
+			if s[i] == '"' && s[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		return j + 1, line
+	case s[i] == '[':
+		i++
+		for n := 0; i < len(s); {
+			switch c := s[i]; {
+			case c == ']':
+				return i + 1, line
+			case c == '\n':
+				line++
+				i++
+			case c == '#':
+				for i < len(s) && s[i] != '\n' {
+					i++
+				}
+			case c == ' ' || c == '\t' || c == '\r' || c == ',':
+				i++
+			default:
+				itemPath := fmt.Sprintf("%s[%d]", path, n)
+				lines[itemPath] = line
+				n++
+				i, line = tomlValueLines(s, i, line, itemPath, lines)
+			}
+		}
+		return i, line
+	case s[i] == '{':
+		// inline table: keys are on the same line
+		depth := 0
+		for ; i < len(s); i++ {
+			switch s[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					return i + 1, line
+				}
+			case '\n':
+				line++
+			}
+		}
+		return i, line
+	}
+	// number, boolean or date
+	for i < len(s) && strings.IndexByte(",]} \t\r\n#", s[i]) < 0 {
+		i++
+	}
+	return i, line
+}
+
+// tomlKey splits dotted TOML key, like `a."b.c"`, into parts.
+func tomlKey(s string) []string {
+	var keys []string
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			if quote == '"' && c == '\\' && i+1 < len(s) {
+				i++
+				c = s[i]
+			}
+			b.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '.':
+			keys = append(keys, b.String())
+			b.Reset()
+		case c == ' ' || c == '\t':
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(keys, b.String())
+}
+
+// configSyntaxError returns config error for malformed file.
+func configSyntaxError(msg string, line int) error {
+	return ConfigErrors{{
+		Line:  line,
+		Field: "config",
+		Msg:   msg,
+	}}
+}
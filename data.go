@@ -1,17 +1,110 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"sort"
+	"time"
+)
 
 // UIData represents data to be passed to UI.
+//
+// Patterns are monitored vars, as configured, and Vars are vars
+// to display, with wildcard patterns expanded into vars found
+// in services data (see ExpandVars).
 type UIData struct {
 	Services      []*Service
 	Vars          []VarName
+	Patterns      []VarName
 	LastTimestamp time.Time
+
+	// Info is an additional status info (replay position, for example),
+	// displayed along with the last update time.
+	Info string
+
+	// Alerts, if set, holds alert rules and their state.
+	Alerts *Alerts
+
+	// Profiler, if set, captures pprof profiles of services.
+	Profiler *Profiler
+
+	// Goroutines, if set, tracks goroutine stacks of the first service.
+	Goroutines *Goroutines
+
+	// Redraw is notified by background work of UI (like fetches
+	// of vars browser), when UI should be redrawn.
+	Redraw chan struct{}
+}
+
+// notify sends notification to the buffered channel ch, if it's
+// not nil and has no pending notification yet.
+func notify(ch chan<- struct{}) {
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// StatusText returns text for the status bar: last update time,
+// additional info and profile capture status.
+func (d UIData) StatusText() string {
+	text := fmt.Sprintf("Last update: %v %s", d.LastTimestamp.Format(time.Stamp), d.Info)
+	if d.Profiler != nil {
+		if status := d.Profiler.Status(); status != "" {
+			text += " | " + status
+		}
+	}
+	return text
 }
 
 // NewUIData inits and return new data object.
 func NewUIData(vars []VarName) *UIData {
 	return &UIData{
-		Vars: vars,
+		Vars:     vars,
+		Patterns: append([]VarName{}, vars...),
+		Redraw:   make(chan struct{}, 1),
+	}
+}
+
+// ExpandVars updates Vars, expanding wildcard patterns into vars found
+// in any of services, up to maxSeries vars per pattern. Patterns without
+// matches yet are displayed as is.
+func (d *UIData) ExpandVars() {
+	var vars []VarName
+	seen := make(map[VarName]bool)
+	add := func(name VarName) {
+		if !seen[name] {
+			seen[name] = true
+			vars = append(vars, name)
+		}
+	}
+
+	for _, pattern := range d.Patterns {
+		if !pattern.Wildcard() {
+			add(pattern)
+			continue
+		}
+
+		var expanded []VarName
+		for _, service := range d.Services {
+			expanded = append(expanded, service.Expanded(pattern)...)
+		}
+		sort.Slice(expanded, func(i, j int) bool { return expanded[i] < expanded[j] })
+		if len(expanded) == 0 {
+			add(pattern)
+		}
+		n := 0
+		for i, name := range expanded {
+			if n >= *maxSeries {
+				break
+			}
+			if i == 0 || name != expanded[i-1] {
+				add(name)
+				n++
+			}
+		}
 	}
+	d.Vars = vars
 }
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"debug/buildinfo"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discoveryMarkTTL is a time newly discovered services are marked as new,
+// and vanished services are kept before removing.
+const discoveryMarkTTL = time.Minute
+
+// Discoverer finds services to monitor.
+type Discoverer interface {
+	Discover() ([]url.URL, error)
+}
+
+// ParseDiscoverers parses comma-separated list of discovery providers:
+// "file:PATH", "srv:NAME" or "local".
+func ParseDiscoverers(s string) ([]Discoverer, error) {
+	var ret []Discoverer
+	for _, field := range strings.Split(s, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		d, err := parseDiscoverer(field)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+func parseDiscoverer(s string) (Discoverer, error) {
+	s = strings.TrimSpace(s)
+	kind, arg := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, arg = s[:i], s[i+1:]
+	}
+	switch {
+	case kind == "file" && arg != "":
+		return &FileDiscoverer{Path: arg}, nil
+	case kind == "srv" && arg != "":
+		return &SRVDiscoverer{Name: arg}, nil
+	case kind == "local" && arg == "":
+		return NewLocalDiscoverer("/proc"), nil
+	}
+	return nil, fmt.Errorf("unknown discovery provider %q, should be file:PATH, srv:NAME or local", s)
+}
+
+// FileDiscoverer reads targets from file, re-reading it when it changes.
+//
+// File contains targets in -ports format, one or more per line.
+// Empty lines and lines starting with # are ignored.
+type FileDiscoverer struct {
+	Path string
+
+	modTime time.Time
+	urls    []url.URL
+}
+
+// Discover implements Discoverer.
+func (d *FileDiscoverer) Discover() ([]url.URL, error) {
+	fi, err := os.Stat(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.ModTime().Equal(d.modTime) {
+		return d.urls, nil
+	}
+
+	data, err := ioutil.ReadFile(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	urls, err := ParsePorts(strings.Join(targets, ","))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", d.Path, err)
+	}
+
+	d.modTime, d.urls = fi.ModTime(), urls
+	return urls, nil
+}
+
+// SRVDiscoverer finds targets using DNS SRV records, like
+// "_expvar._tcp.example.com".
+type SRVDiscoverer struct {
+	Name string
+}
+
+// Discover implements Discoverer.
+func (d *SRVDiscoverer) Discover() ([]url.URL, error) {
+	_, addrs, err := net.LookupSRV("", "", d.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []url.URL
+	for _, addr := range addrs {
+		host := strings.TrimSuffix(addr.Target, ".")
+		urls = append(urls, url.URL{
+			Scheme: "http",
+			Host:   net.JoinHostPort(host, strconv.Itoa(int(addr.Port))),
+			Path:   DefaultEndpoint,
+		})
+	}
+	return urls, nil
+}
+
+// LocalDiscoverer finds listening TCP ports of local Go processes,
+// using /proc filesystem, and probes them for expvar endpoint.
+type LocalDiscoverer struct {
+	Root string
+
+	// probed caches probe results, as the same process
+	// will serve the same content on the same port.
+	probed map[localTarget]bool
+}
+
+type localTarget struct {
+	pid  int
+	addr string
+}
+
+// NewLocalDiscoverer returns new LocalDiscoverer for the given /proc root.
+func NewLocalDiscoverer(root string) *LocalDiscoverer {
+	return &LocalDiscoverer{
+		Root:   root,
+		probed: make(map[localTarget]bool),
+	}
+}
+
+// Discover implements Discoverer.
+func (d *LocalDiscoverer) Discover() ([]url.URL, error) {
+	listeners := make(map[string]string) // socket inode -> addr
+	for _, name := range []string{"tcp", "tcp6"} {
+		file, err := os.Open(filepath.Join(d.Root, "net", name))
+		if err != nil {
+			if name == "tcp6" {
+				continue
+			}
+			return nil, err
+		}
+		for inode, addr := range parseProcNetTCP(file) {
+			listeners[inode] = addr
+		}
+		file.Close()
+	}
+
+	dirs, err := ioutil.ReadDir(d.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []url.URL
+	seen := make(map[localTarget]bool)
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range d.processListeners(pid, listeners) {
+			target := localTarget{pid, addr}
+			seen[target] = true
+
+			ok, probed := d.probed[target]
+			if !probed {
+				ok = d.probe(target)
+				d.probed[target] = ok
+			}
+			if ok {
+				urls = append(urls, url.URL{Scheme: "http", Host: addr, Path: DefaultEndpoint})
+			}
+		}
+	}
+
+	// forget exited processes
+	for target := range d.probed {
+		if !seen[target] {
+			delete(d.probed, target)
+		}
+	}
+	return urls, nil
+}
+
+// processListeners returns addresses of listening sockets, opened by process.
+func (d *LocalDiscoverer) processListeners(pid int, listeners map[string]string) []string {
+	fdDir := filepath.Join(d.Root, strconv.Itoa(pid), "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		// other users processes are not accessible
+		return nil
+	}
+
+	var addrs []string
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+		if addr, ok := listeners[inode]; ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// probe checks that target is a Go process, serving expvars.
+func (d *LocalDiscoverer) probe(target localTarget) bool {
+	exe := filepath.Join(d.Root, strconv.Itoa(target.pid), "exe")
+	if _, err := buildinfo.ReadFile(exe); err != nil {
+		return false
+	}
+	u := url.URL{Scheme: "http", Host: target.addr, Path: DefaultEndpoint}
+	_, err := FetchExpvar(u)
+	return err == nil
+}
+
+// parseProcNetTCP parses /proc/net/tcp[6] file and returns addresses
+// of listening sockets by their inodes.
+func parseProcNetTCP(file *os.File) map[string]string {
+	ret := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // skip header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// 0A is TCP_LISTEN state
+		if len(fields) < 10 || fields[3] != "0A" {
+			continue
+		}
+
+		local := strings.Split(fields[1], ":")
+		if len(local) != 2 {
+			continue
+		}
+		port, err := strconv.ParseUint(local[1], 16, 16)
+		if err != nil {
+			continue
+		}
+		ret[fields[9]] = net.JoinHostPort(procNetHost(local[0]), strconv.Itoa(int(port)))
+	}
+	return ret
+}
+
+// procNetHost converts hex-encoded address from /proc/net/tcp to host
+// to connect to. Wildcard and IPv6 addresses are replaced with localhost.
+func procNetHost(s string) string {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 4 {
+		return "localhost"
+	}
+	// address is stored in host byte order, which is little endian
+	ip := net.IPv4(b[3], b[2], b[1], b[0])
+	if ip.IsUnspecified() || ip.IsLoopback() {
+		return "localhost"
+	}
+	return ip.String()
+}
+
+// DiscoveryResult represents services found by all discoverers.
+type DiscoveryResult struct {
+	URLs []url.URL
+	Err  error
+}
+
+// Discovery periodically runs discoverers in background.
+type Discovery struct {
+	Discoverers []Discoverer
+	Interval    time.Duration
+
+	last map[Discoverer][]url.URL
+}
+
+// Run starts discovery, sending results to the returned channel.
+func (d *Discovery) Run() <-chan DiscoveryResult {
+	d.last = make(map[Discoverer][]url.URL)
+	ch := make(chan DiscoveryResult)
+	go func() {
+		for {
+			ch <- d.discover()
+			time.Sleep(d.Interval)
+		}
+	}()
+	return ch
+}
+
+// discover runs all discoverers once. Results of failed discoverers
+// are taken from their last successful run.
+func (d *Discovery) discover() DiscoveryResult {
+	var res DiscoveryResult
+	seen := make(map[string]bool)
+	for _, discoverer := range d.Discoverers {
+		urls, err := discoverer.Discover()
+		if err != nil {
+			res.Err = err
+			urls = d.last[discoverer]
+		}
+		d.last[discoverer] = urls
+
+		for _, u := range urls {
+			if seen[u.String()] {
+				continue
+			}
+			seen[u.String()] = true
+			res.URLs = append(res.URLs, u)
+		}
+	}
+	return res
+}
+
+// SyncServices updates data with the discovered services: new services
+// are added with the given vars, missing ones are marked as vanished and
+// removed later. Services not created by discovery are kept as is.
+// It returns true if any service was added, removed or marked.
+func SyncServices(data *UIData, urls []url.URL, vars []VarName, now time.Time) bool {
+	found := make(map[string]bool)
+	for _, u := range urls {
+		found[u.String()] = true
+	}
+
+	var changed bool
+	var services []*Service
+	known := make(map[string]bool)
+	for _, service := range data.Services {
+		known[service.URL.String()] = true
+		if !service.Discovered {
+			services = append(services, service)
+			continue
+		}
+
+		switch {
+		case found[service.URL.String()]:
+			changed = changed || !service.Vanished.IsZero()
+			service.Vanished = time.Time{}
+		case service.Vanished.IsZero():
+			service.Vanished = now
+			changed = true
+		case now.Sub(service.Vanished) > discoveryMarkTTL:
+			if data.Alerts != nil {
+				data.Alerts.Remove(service)
+			}
+			changed = true
+			continue
+		}
+		services = append(services, service)
+	}
+
+	for _, u := range urls {
+		if known[u.String()] {
+			continue
+		}
+		service := NewService(u, vars)
+		service.Discovered = true
+		service.Found = now
+		services = append(services, service)
+		changed = true
+	}
+	data.Services = services
+	return changed
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFileDiscoverer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expvarmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "targets.txt")
+	content := "# local services\n1234-1235\n\nhttp://example.com:80\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &FileDiscoverer{Path: path}
+	urls, err := d.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 3 || urls[2].Host != "example.com:80" {
+		t.Fatalf("Expecting 3 urls, but got %v", urls)
+	}
+
+	if _, err := parseDiscoverer("file:" + path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseDiscoverer("unknown"); err == nil {
+		t.Fatalf("Expecting unknown discovery provider to fail")
+	}
+}
+
+func TestLocalDiscoverer(t *testing.T) {
+	server := httptest.NewServer(expvar.Handler())
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	// fake /proc with current process listening on server port
+	root, err := ioutil.TempDir("", "expvarmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	tcp := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n" +
+		fmt.Sprintf("   0: 0100007F:%04X 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 4242 1 0 100 0 0 10 0\n", p) +
+		"   1: 0100007F:1F90 0100007F:D431 01 00000000:00000000 00:00000000 00000000  1000        0 4343 1 0 100 0 0 10 0\n"
+	os.MkdirAll(filepath.Join(root, "net"), 0755)
+	os.MkdirAll(filepath.Join(root, "42", "fd"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "net", "tcp"), []byte(tcp), 0644)
+	os.Symlink("socket:[4242]", filepath.Join(root, "42", "fd", "3"))
+	os.Symlink("socket:[4343]", filepath.Join(root, "42", "fd", "4"))
+	exe, _ := os.Executable()
+	os.Symlink(exe, filepath.Join(root, "42", "exe"))
+
+	d := NewLocalDiscoverer(root)
+	urls, err := d.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].Host != "localhost:"+port {
+		t.Fatalf("Expecting server to be discovered, but got %v", urls)
+	}
+}
+
+func TestSyncServices(t *testing.T) {
+	static := NewService(url.URL{Host: "localhost:1000"}, nil)
+	data := &UIData{Services: []*Service{static}}
+	vars := []VarName{"memstats.NumGC"}
+
+	a, b := url.URL{Host: "localhost:1001"}, url.URL{Host: "localhost:1002"}
+	now := time.Now()
+
+	if !SyncServices(data, []url.URL{a, b}, vars, now) {
+		t.Fatalf("Expecting services to be changed")
+	}
+	if len(data.Services) != 3 || !data.Services[1].Discovered {
+		t.Fatalf("Expecting 2 services to be discovered, but got %d services", len(data.Services))
+	}
+	if _, ok := data.Services[2].stacks["memstats.NumGC"]; !ok {
+		t.Fatalf("Expecting discovered service to monitor vars")
+	}
+
+	SyncServices(data, []url.URL{b}, vars, now.Add(time.Second))
+	if len(data.Services) != 3 || data.Services[1].Vanished.IsZero() {
+		t.Fatalf("Expecting service to be marked as vanished")
+	}
+
+	if SyncServices(data, []url.URL{b}, vars, now.Add(time.Second)) {
+		t.Fatalf("Expecting services not to be changed")
+	}
+
+	SyncServices(data, []url.URL{b}, vars, now.Add(2*discoveryMarkTTL))
+	if len(data.Services) != 2 || data.Services[0] != static || data.Services[1].URL != b {
+		t.Fatalf("Expecting vanished service to be removed, but got %d services", len(data.Services))
+	}
+}
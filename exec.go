@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/antonholmquist/jason"
+)
+
+// ExecScheme is URL scheme of services, which expvar JSON is obtained
+// by running shell command, like "exec:kubectl exec app -- curl -s
+// localhost:1234/debug/vars".
+const ExecScheme = "exec"
+
+// execEscaper escapes chars, which can't be in opaque URL part.
+var execEscaper = strings.NewReplacer("%", "%25", "?", "%3F", "#", "%23")
+
+// ExecURL returns URL for the command.
+func ExecURL(command string) url.URL {
+	return url.URL{
+		Scheme: ExecScheme,
+		Opaque: execEscaper.Replace(command),
+	}
+}
+
+// ExecCommand returns command of exec URL.
+func ExecCommand(u url.URL) (string, bool) {
+	if u.Scheme != ExecScheme {
+		return "", false
+	}
+	command, err := url.PathUnescape(u.Opaque)
+	if err != nil {
+		return u.Opaque, true
+	}
+	return command, true
+}
+
+// execName returns name of the exec service: base name of the command,
+// without arguments, which may hold credentials.
+func execName(command string) string {
+	for _, field := range strings.Fields(command) {
+		// skip environment variables, like "TOKEN=xxx kubectl ..."
+		if strings.Contains(field, "=") && !strings.ContainsAny(field, `/\`) {
+			continue
+		}
+		return BaseCommand([]string{strings.Trim(field, `"'`)})
+	}
+	return ExecScheme
+}
+
+// redactCommand returns command for displaying and exporting, with
+// URLs redacted by RedactURL, and values of sensitive flags (like
+// "--token"), environment variables, auth headers and curl user
+// passwords replaced by "xxxxx".
+func redactCommand(command string) string {
+	fields := strings.Fields(command)
+	for i := 0; i < len(fields); i++ {
+		value := strings.Trim(fields[i], `"'`)
+		lower := strings.ToLower(value)
+
+		switch {
+		case strings.Contains(value, "://"):
+			if u, err := url.Parse(value); err == nil && u.Host != "" {
+				fields[i] = replaceQuoted(fields[i], RedactURL(*u))
+			}
+		case strings.Contains(value, "="):
+			kv := strings.SplitN(value, "=", 2)
+			if isSensitive(kv[0]) {
+				fields[i] = replaceQuoted(fields[i], kv[0]+"=xxxxx")
+			}
+		case strings.HasSuffix(lower, ":") && (lower == "authorization:" || isSensitive(lower[:len(lower)-1])):
+			// header, like -H "Authorization: Bearer xxx"
+			next := i + 1
+			if next < len(fields) && next+1 < len(fields) && authSchemes[strings.ToLower(strings.Trim(fields[next], `"'`))] {
+				next++
+			}
+			if next < len(fields) {
+				fields[next] = replaceQuoted(fields[next], "xxxxx")
+				i = next
+			}
+		case (lower == "-u" || lower == "--user") && i+1 < len(fields):
+			i++
+			user := strings.Trim(fields[i], `"'`)
+			if j := strings.IndexByte(user, ':'); j >= 0 {
+				fields[i] = replaceQuoted(fields[i], user[:j]+":xxxxx")
+			}
+		case strings.HasPrefix(value, "-") && isSensitive(value) && i+1 < len(fields):
+			i++
+			fields[i] = replaceQuoted(fields[i], "xxxxx")
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// authSchemes are schemes of Authorization header, kept in redacted commands.
+var authSchemes = map[string]bool{"bearer": true, "basic": true, "token": true, "digest": true}
+
+// isSensitive returns true if name of flag, variable or header
+// (like "--api-key" or "GITHUB_TOKEN") refers to sensitive value.
+func isSensitive(name string) bool {
+	name = strings.ToLower(strings.TrimLeft(name, "-"))
+	name = strings.Replace(name, "-", "_", -1)
+	for _, param := range sensitiveParams {
+		if name == param || strings.HasSuffix(name, "_"+param) || strings.HasPrefix(name, param+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceQuoted replaces field value, keeping surrounding quotes.
+func replaceQuoted(field, value string) string {
+	trimmed := strings.TrimLeft(field, `"'`)
+	prefix := field[:len(field)-len(trimmed)]
+	trimmed = strings.TrimRight(trimmed, `"'`)
+	suffix := field[len(prefix)+len(trimmed):]
+	return prefix + value + suffix
+}
+
+// ExecSource obtains expvar JSON by running shell command
+// and parsing its output.
+type ExecSource struct {
+	Command string
+	Timeout time.Duration
+}
+
+// Fetch implements Source.
+func (s ExecSource) Fetch([]VarName) (*Expvar, error) {
+	e := &Expvar{&jason.Object{}}
+
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", s.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", s.Command)
+	}
+	// don't wait for children, holding output open, after kill
+	cmd.WaitDelay = 100 * time.Millisecond
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return e, fmt.Errorf("command timed out after %v: %w", s.Timeout, ctx.Err())
+	}
+	if err != nil {
+		return e, commandError(err, stderr.String())
+	}
+
+	expvar, err := ParseExpvar(&stdout)
+	if err != nil {
+		return e, fmt.Errorf("invalid command output: %v", err)
+	}
+	return expvar, nil
+}
+
+// commandError returns error of failed command, with the last
+// line of its stderr, which usually describes the problem.
+func commandError(err error, stderr string) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = fmt.Errorf("command failed with exit status %d", exitErr.ExitCode())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return fmt.Errorf("%v: %s", err, last)
+	}
+	return err
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter is an UI implementation, which serves collected data
+// over HTTP, as JSON (/debug/vars) and Prometheus metrics (/metrics).
+//
+// It allows expvarmon to act as an aggregator for many services.
+type Exporter struct {
+	Addr string
+
+	listener net.Listener
+	rates    map[exportKey]*Rate
+
+	mu       sync.RWMutex
+	snapshot ExportData
+}
+
+// ExportData represents data served by Exporter.
+type ExportData struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Services  []ExportService `json:"services"`
+}
+
+// ExportService represents exported service data.
+type ExportService struct {
+	Name      string               `json:"name"`
+	URL       string               `json:"url"`
+	Err       string               `json:"err,omitempty"`
+	Status    string               `json:"status"`
+	Restarted bool                 `json:"restarted"`
+	Vars      map[string]ExportVar `json:"vars"`
+}
+
+// ExportVar represents exported var data: latest value,
+// maximum value and per-second rate of change.
+type ExportVar struct {
+	Value     VarValue `json:"value"`
+	Formatted string   `json:"formatted"`
+	Max       VarValue `json:"max"`
+	Rate      VarValue `json:"rate"`
+}
+
+type exportKey struct {
+	service *Service
+	name    VarName
+}
+
+// NewExporter returns new Exporter, listening on addr.
+func NewExporter(addr string) *Exporter {
+	return &Exporter{
+		Addr:  addr,
+		rates: make(map[exportKey]*Rate),
+	}
+}
+
+// Init implements UI.
+func (e *Exporter) Init(UIData) error {
+	l, err := net.Listen("tcp", e.Addr)
+	if err != nil {
+		return err
+	}
+	e.listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", e.serveJSON)
+	mux.HandleFunc("/metrics", e.serveMetrics)
+	go http.Serve(l, mux)
+	return nil
+}
+
+// Close implements UI.
+func (e *Exporter) Close() {
+	e.listener.Close()
+}
+
+// Update implements UI.
+func (e *Exporter) Update(data UIData) {
+	// UI may be updated without fetching new data (on resize, for example)
+	if !data.LastTimestamp.After(e.snapshotTime()) {
+		return
+	}
+
+	snapshot := ExportData{Timestamp: data.LastTimestamp}
+	seen := make(map[exportKey]bool)
+	for _, service := range data.Services {
+		es := ExportService{
+			Name:      service.Name,
+			URL:       RedactURL(service.URL),
+			Status:    service.Status.String(),
+			Restarted: service.Restarted,
+			Vars:      make(map[string]ExportVar),
+		}
+		if service.Err != nil {
+			es.Err = service.Err.Error()
+		}
+
+		for _, name := range data.Vars {
+			stack, ok := service.stacks[name]
+			if !ok {
+				continue
+			}
+
+			key := exportKey{service, name}
+			seen[key] = true
+			rate, ok := e.rates[key]
+			if !ok {
+				// only counters of rate: vars may be reset,
+				// other vars can go down as well as up
+				rate = &Rate{Signed: !name.Rate()}
+				e.rates[key] = rate
+			}
+
+			v := stack.Front()
+			ev := ExportVar{
+				Value: v,
+				Max:   stack.Max,
+				Rate:  rate.Update(v, data.LastTimestamp),
+			}
+			if v != nil {
+				ev.Formatted = name.Format(v)
+			}
+			es.Vars[string(name)] = ev
+		}
+		snapshot.Services = append(snapshot.Services, es)
+	}
+
+	// forget rates of removed services and vars
+	for key := range e.rates {
+		if !seen[key] {
+			delete(e.rates, key)
+		}
+	}
+
+	e.mu.Lock()
+	e.snapshot = snapshot
+	e.mu.Unlock()
+}
+
+func (e *Exporter) snapshotTime() time.Time {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.snapshot.Timestamp
+}
+
+func (e *Exporter) serveJSON(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(e.snapshot)
+}
+
+func (e *Exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(e.snapshot.Metrics())
+}
+
+// Metrics returns data in Prometheus text exposition format.
+func (d ExportData) Metrics() []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintln(&buf, "# HELP expvarmon_up Whether the last fetch of service succeeded.")
+	fmt.Fprintln(&buf, "# TYPE expvarmon_up gauge")
+	for _, s := range d.Services {
+		up := 1
+		if s.Err != "" {
+			up = 0
+		}
+		fmt.Fprintf(&buf, "expvarmon_up{%s} %d\n", serviceLabels(s), up)
+	}
+
+	metrics := []struct {
+		name, help string
+		value      func(ExportVar) VarValue
+	}{
+		{"expvarmon_value", "Latest value of the var.", func(v ExportVar) VarValue { return v.Value }},
+		{"expvarmon_max", "Maximum recorded value of the var.", func(v ExportVar) VarValue { return v.Max }},
+		{"expvarmon_rate", "Per-second rate of change of the var.", func(v ExportVar) VarValue { return v.Rate }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", m.name)
+		for _, s := range d.Services {
+			var names []string
+			for name := range s.Vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				f, ok := metricValue(m.value(s.Vars[name]))
+				if !ok {
+					continue
+				}
+				fmt.Fprintf(&buf, "%s{%s,var=%s} %s\n", m.name, serviceLabels(s), quoteLabel(name), f)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// serviceLabels returns Prometheus labels for the service.
+func serviceLabels(s ExportService) string {
+	return fmt.Sprintf("service=%s,url=%s", quoteLabel(s.Name), quoteLabel(s.URL))
+}
+
+// quoteLabel quotes label value according to Prometheus text format.
+func quoteLabel(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// metricValue formats numeric or bool value for Prometheus.
+func metricValue(v VarValue) (string, bool) {
+	if b, ok := v.(bool); ok {
+		if b {
+			return "1", true
+		}
+		return "0", true
+	}
+	f, ok := toFloat64(v)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antonholmquist/jason"
+)
+
+func TestExporter(t *testing.T) {
+	vars := []VarName{"mem:memstats.Alloc", "goroutines"}
+	ok, failed := NewService(NewURL("1234"), vars), NewService(NewURL("1235"), vars)
+	data := NewUIData(vars)
+	data.Services = []*Service{ok, failed}
+
+	e := NewExporter("")
+	start := time.Now()
+	for i, alloc := range []string{"1000", "3000", "2000"} {
+		obj, _ := jason.NewObjectFromBytes([]byte(`{"cmdline": ["app"], "memstats": {"Alloc": ` + alloc + `, "PauseTotalNs": 1}, "goroutines": 10}`))
+		data.LastTimestamp = start.Add(time.Duration(i) * time.Second)
+		ok.update(&Expvar{obj}, nil, data.LastTimestamp)
+		failed.update(&Expvar{&jason.Object{}}, errors.New("connection refused"), data.LastTimestamp)
+		e.Update(*data)
+	}
+
+	w := httptest.NewRecorder()
+	e.serveJSON(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	var snapshot ExportData
+	if err := json.NewDecoder(w.Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Services) != 2 || snapshot.Services[1].Err != "connection refused" {
+		t.Fatalf("Exported wrong data: %+v", snapshot)
+	}
+	alloc := snapshot.Services[0].Vars["mem:memstats.Alloc"]
+	// decreasing value of non-rate var gives negative rate
+	if alloc.Value != 2000.0 || alloc.Max != 3000.0 || alloc.Rate != -1000.0 || alloc.Formatted != "2.0KB" {
+		t.Fatalf("Exported wrong var data: %+v", alloc)
+	}
+
+	metrics := string(snapshot.Metrics())
+	for _, line := range []string{
+		`expvarmon_up{service="app",url="http://localhost:1234/debug/vars"} 1`,
+		`expvarmon_up{service="localhost:1235",url="http://localhost:1235/debug/vars"} 0`,
+		`expvarmon_value{service="app",url="http://localhost:1234/debug/vars",var="mem:memstats.Alloc"} 2000`,
+		`expvarmon_max{service="app",url="http://localhost:1234/debug/vars",var="mem:memstats.Alloc"} 3000`,
+		`expvarmon_rate{service="app",url="http://localhost:1234/debug/vars",var="goroutines"} 0`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Fatalf("Expecting metrics to contain %q, but got:\n%s", line, metrics)
+		}
+	}
+
+	// rates of removed services are forgotten
+	data.Services = []*Service{ok}
+	data.LastTimestamp = start.Add(3 * time.Second)
+	e.Update(*data)
+	for key := range e.rates {
+		if key.service == failed {
+			t.Fatalf("Expecting rates of removed service to be deleted, but got %v", key.name)
+		}
+	}
+	if len(e.rates) != len(vars) {
+		t.Fatalf("Expecting %d rates, but got %d", len(vars), len(e.rates))
+	}
+}
@@ -3,10 +3,10 @@ package main
 import (
 	"errors"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"time"
 
 	"github.com/antonholmquist/jason"
 )
@@ -25,28 +25,34 @@ func getBasicAuthEnv() (user, password string) {
 
 // FetchExpvar fetches expvar by http for the given addr (host:port)
 func FetchExpvar(u url.URL) (*Expvar, error) {
-	e := &Expvar{&jason.Object{}}
-	client := &http.Client{
-		Timeout: 1 * time.Second, // TODO: make it configurable or left default?
-	}
+	return fetchExpvar(nil, u)
+}
 
-	req, _ := http.NewRequest("GET", "localhost", nil)
-	req.URL = &u
-	req.Host = u.Host
+// fetchExpvar fetches expvar using the given client.
+func fetchExpvar(client *http.Client, u url.URL) (*Expvar, error) {
+	return fetchPaths(client, u, nil, nil)
+}
 
-	if user, pass := getBasicAuthEnv(); user != "" && pass != "" {
-		req.SetBasicAuth(user, pass)
-	}
-	resp, err := client.Do(req)
+// fetchPaths fetches expvar using the given client, extracting
+// only values at the given paths. Nil paths means all data.
+// Response info is recorded by rec, if set.
+func fetchPaths(client *http.Client, u url.URL, paths pathTrie, rec *responseRecorder) (*Expvar, error) {
+	e := &Expvar{&jason.Object{}}
+	resp, err := fetch(client, u)
+	rec.track(resp)
 	if err != nil {
 		return e, err
 	}
-	defer resp.Body.Close()
+	defer func() {
+		// drain body, so connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
 	if resp.StatusCode == http.StatusNotFound {
 		return e, errors.New("Vars not found. Did you import expvars?")
 	}
 
-	expvar, err := ParseExpvar(resp.Body)
+	expvar, err := extractExpvar(resp.Body, paths)
 	if err != nil {
 		return e, err
 	}
@@ -54,6 +60,31 @@ func FetchExpvar(u url.URL) (*Expvar, error) {
 	return e, nil
 }
 
+// fetch performs GET request for the given URL. If client is nil,
+// client with default options is used.
+func fetch(client *http.Client, u url.URL) (*http.Response, error) {
+	if client == nil {
+		// default options are validated on start
+		client, _ = NewHTTPClient(DefaultFetchOptions, u)
+	}
+
+	target := u
+	_, _, unix := UnixSocket(u)
+	if unix {
+		target = unixHTTPURL(u)
+	}
+	req, _ := http.NewRequest("GET", "localhost", nil)
+	req.URL = &target
+	req.Host = target.Host
+
+	resp, err := client.Do(req)
+	if uerr, ok := err.(*url.Error); ok && unix {
+		// show socket URL instead of HTTP one
+		uerr.URL = u.String()
+	}
+	return resp, wrapTLSError(redactError(err))
+}
+
 // ParseExpvar parses expvar data from reader.
 func ParseExpvar(r io.Reader) (*Expvar, error) {
 	object, err := jason.NewObjectFromReader(r)
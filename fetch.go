@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FetchOptions configures fetching of the service data.
+type FetchOptions struct {
+	// Timeout is a timeout of the single fetch attempt.
+	Timeout time.Duration
+	// Retries is a number of retries of the failed fetch within single poll.
+	Retries int
+	// MaxBackoff limits exponential backoff between polls of the
+	// service which is down.
+	MaxBackoff time.Duration
+	// Slow is a fetch duration after which service is marked as slow.
+	// Defaults to half of the timeout.
+	Slow time.Duration
+	// TLS configures connections to https services.
+	TLS TLSOptions
+	// Auth configures credentials, sent to the service.
+	Auth AuthOptions
+	// Full disables extraction of monitored vars only, so whole
+	// documents are parsed (i.e. for recording raw JSON).
+	Full bool
+}
+
+// DefaultFetchOptions are used for services without specific options.
+var DefaultFetchOptions = FetchOptions{
+	Timeout:    time.Second,
+	MaxBackoff: time.Minute,
+}
+
+// SlowThreshold returns fetch duration after which service is slow.
+func (o FetchOptions) SlowThreshold() time.Duration {
+	if o.Slow != 0 {
+		return o.Slow
+	}
+	return o.Timeout / 2
+}
+
+// sharedTransport is used by all services, unless they need specific
+// TLS or dial settings, so connections are kept alive and reused
+// between polls. Gzipped responses are decompressed transparently.
+var sharedTransport = newSharedTransport()
+
+func newSharedTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 0 // no limit, as there may be hundreds of services
+	t.MaxIdleConnsPerHost = 4
+	return t
+}
+
+// NewHTTPClient returns HTTP client for the service URL, configured
+// with options. Credentials are sent only to the service host.
+func NewHTTPClient(o FetchOptions, u url.URL) (*http.Client, error) {
+	host := u.Host
+	var base http.RoundTripper = sharedTransport
+	if !o.TLS.IsZero() {
+		cfg, err := o.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+		transport := sharedTransport.Clone()
+		transport.TLSClientConfig = cfg
+		base = transport
+	}
+	if socket, _, ok := UnixSocket(u); ok {
+		base, host = unixTransport(base, socket), unixHost
+	}
+	return &http.Client{
+		Timeout:   o.Timeout,
+		Transport: newAuthTransport(base, host, o.Auth),
+	}, nil
+}
+
+// FetchStatus describes result of the last fetch of the service.
+type FetchStatus int
+
+// Fetch statuses.
+const (
+	StatusOK FetchStatus = iota
+	StatusSlow
+	StatusTimeout
+	StatusRefused
+	StatusTLS
+	StatusFailed
+)
+
+func (s FetchStatus) String() string {
+	switch s {
+	case StatusSlow:
+		return "slow"
+	case StatusTimeout:
+		return "timeout"
+	case StatusRefused:
+		return "refused"
+	case StatusTLS:
+		return "tls"
+	case StatusFailed:
+		return "failed"
+	}
+	return "ok"
+}
+
+// fetchStatus returns status for the fetch result and its duration.
+func fetchStatus(err error, latency, slow time.Duration) FetchStatus {
+	if err == nil {
+		if slow > 0 && latency > slow {
+			return StatusSlow
+		}
+		return StatusOK
+	}
+	if _, ok := tlsReason(err); ok {
+		return StatusTLS
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout(),
+		errors.Is(err, context.DeadlineExceeded):
+		return StatusTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return StatusRefused
+	}
+
+	// replayed errors are available only as text
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "Client.Timeout exceeded"),
+		strings.Contains(msg, "i/o timeout"),
+		strings.Contains(msg, "deadline exceeded"):
+		return StatusTimeout
+	case strings.Contains(msg, "connection refused"):
+		return StatusRefused
+	}
+	return StatusFailed
+}
+
+// backoff returns delay before the next poll of the service, failed
+// the given number of times in a row. Delay grows exponentially from
+// the polling interval up to max, with random jitter, so services
+// that are down are not hammered on every tick.
+func backoff(failures int, interval, max time.Duration) time.Duration {
+	if failures < 2 || max <= interval {
+		return 0
+	}
+
+	d := interval
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	// jitter in range [d/2, d]
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antonholmquist/jason"
+)
+
+func TestFetchStatus(t *testing.T) {
+	// closed port to get connection refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, err = fetchExpvar(nil, url.URL{Scheme: "http", Host: addr, Path: "/debug/vars"})
+	if s := fetchStatus(err, 0, 0); s != StatusRefused {
+		t.Fatalf("Expecting status to be refused, but got %v (%v)", s, err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	client, _ := NewHTTPClient(FetchOptions{Timeout: 10 * time.Millisecond}, *u)
+	_, err = fetchExpvar(client, *u)
+	if s := fetchStatus(err, 0, 0); s != StatusTimeout {
+		t.Fatalf("Expecting status to be timeout, but got %v (%v)", s, err)
+	}
+	// replayed errors are classified by text
+	if s := fetchStatus(errors.New(err.Error()), 0, 0); s != StatusTimeout {
+		t.Fatalf("Expecting replayed status to be timeout, but got %v", s)
+	}
+
+	if s := fetchStatus(nil, 600*time.Millisecond, 500*time.Millisecond); s != StatusSlow {
+		t.Fatalf("Expecting status to be slow, but got %v", s)
+	}
+	if s := fetchStatus(errors.New("Vars not found"), 0, 0); s != StatusFailed {
+		t.Fatalf("Expecting status to be failed, but got %v", s)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	interval := 5 * time.Second
+	if d := backoff(1, interval, time.Minute); d != 0 {
+		t.Fatalf("Expecting no backoff after first failure, but got %v", d)
+	}
+	for failures, max := range map[int]time.Duration{2: 10 * time.Second, 3: 20 * time.Second, 10: time.Minute} {
+		d := backoff(failures, interval, time.Minute)
+		if d < max/2 || d > max {
+			t.Fatalf("Expecting backoff after %d failures to be in [%v, %v], but got %v", failures, max/2, max, d)
+		}
+	}
+	if d := backoff(10, interval, interval); d != 0 {
+		t.Fatalf("Expecting backoff to be disabled, but got %v", d)
+	}
+}
+
+type flakySource struct {
+	calls, failures int
+}
+
+func (s *flakySource) Fetch([]VarName) (*Expvar, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return &Expvar{&jason.Object{}}, errors.New("connection refused")
+	}
+	return ParseExpvar(strings.NewReader(`{"cmdline": ["app"], "memstats": {"NumGC": 1, "PauseTotalNs": 1}}`))
+}
+
+func TestServiceRetries(t *testing.T) {
+	service := NewService(url.URL{Host: "localhost:1234"}, []VarName{"memstats.NumGC"})
+	source := &flakySource{failures: 2}
+	service.Source = source
+	service.Options.Retries = 2
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	service.Update(&wg)
+	if service.Err != nil || source.calls != 3 {
+		t.Fatalf("Expecting fetch to succeed after 2 retries, but got %v after %d calls", service.Err, source.calls)
+	}
+
+	// failing service should be backed off
+	source.calls, source.failures = 0, 100
+	service.Options.Retries = 0
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		service.Update(&wg)
+	}
+	if source.calls != 2 || service.Status != StatusRefused || service.NextAttempt.Before(time.Now()) {
+		t.Fatalf("Expecting service to be backed off after 2 failures, but got %d calls", source.calls)
+	}
+}
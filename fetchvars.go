@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// FetchVarPrefix is a prefix of built-in pseudo-vars, describing
+// fetches of the service endpoint itself rather than its data,
+// like "_fetch.latency" or "_fetch.bytes".
+const FetchVarPrefix = "_fetch"
+
+// fetchVars lists fetch pseudo-vars with their default kinds.
+var fetchVars = map[string]VarKind{
+	"latency":  KindDuration,
+	"bytes":    KindMemory,
+	"status":   KindDefault,
+	"failures": KindDefault,
+}
+
+// fetchVar returns field of the fetch pseudo-var ("latency" for
+// "_fetch.latency"), and false if var is not a fetch pseudo-var.
+func (v VarName) fetchVar() (string, bool) {
+	path := v.ToSlice()
+	if len(path) != 2 || path[0] != FetchVarPrefix {
+		return "", false
+	}
+	_, ok := fetchVars[path[1]]
+	return path[1], ok
+}
+
+// fetchValue returns value of the fetch pseudo-var field for the
+// service, or nil if it's not known (i.e. for non-HTTP sources).
+func (s *Service) fetchValue(field string) VarValue {
+	switch field {
+	case "latency":
+		if s.Latency == 0 {
+			// not measured, i.e. in replay
+			return nil
+		}
+		return int64(s.Latency)
+	case "failures":
+		return int64(s.Failures)
+	}
+
+	if s.Response == nil {
+		return nil
+	}
+	switch field {
+	case "bytes":
+		return s.Response.Bytes
+	case "status":
+		return int64(s.Response.StatusCode)
+	}
+	return nil
+}
+
+// ResponseInfo describes HTTP response of the last fetch.
+// StatusCode is zero if request failed without response.
+type ResponseInfo struct {
+	StatusCode int
+	// Bytes is a size of the body after decoding, as gzipped
+	// responses are decompressed transparently by transport.
+	Bytes int64
+}
+
+// responseReporter is implemented by sources, which can report
+// details of the last HTTP response.
+type responseReporter interface {
+	LastResponse() (ResponseInfo, bool)
+}
+
+// responseRecorder records info of the fetched responses.
+// Nil recorder is valid and records nothing.
+type responseRecorder struct {
+	mu   sync.Mutex
+	info ResponseInfo
+}
+
+// LastResponse returns info of the last recorded response,
+// and false if responses are not recorded.
+func (r *responseRecorder) LastResponse() (ResponseInfo, bool) {
+	if r == nil {
+		return ResponseInfo{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.info, true
+}
+
+func (r *responseRecorder) set(info ResponseInfo) {
+	r.mu.Lock()
+	r.info = info
+	r.mu.Unlock()
+}
+
+// track starts recording of the response, wrapping its body to count
+// bytes read; info is recorded once body is closed. Nil response
+// (failed request) is recorded immediately.
+func (r *responseRecorder) track(resp *http.Response) {
+	if r == nil {
+		return
+	}
+	if resp == nil {
+		r.set(ResponseInfo{})
+		return
+	}
+	resp.Body = &countingBody{
+		ReadCloser: resp.Body,
+		done: func(n int64) {
+			r.set(ResponseInfo{StatusCode: resp.StatusCode, Bytes: n})
+		},
+	}
+}
+
+// countingBody counts bytes read from the response body (already
+// decompressed, if it was gzipped), reporting count on close.
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	done func(n int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.done(b.n)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchVars(t *testing.T) {
+	doc := `{"cmdline": ["app"], "memstats": {"Alloc": 1024, "PauseTotalNs": 1}}`
+	found, gzipped := true, false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		if gzipped {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			gz.Write([]byte(doc))
+			return
+		}
+		w.Write([]byte(doc))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/debug/vars")
+
+	vars := []VarName{"_fetch.latency", "_fetch.bytes", "_fetch.status", "_fetch.failures", "rate:_fetch.bytes", "mem:memstats.Alloc"}
+	service := NewService(*u, vars)
+	update := func() {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		service.Update(&wg)
+	}
+
+	update()
+	if service.Err != nil {
+		t.Fatal(service.Err)
+	}
+	if v := service.stacks["_fetch.bytes"].Front(); v != int64(len(doc)) {
+		t.Fatalf("Expecting bytes to be %d, but got %v", len(doc), v)
+	}
+	if v := service.stacks["_fetch.status"].Front(); v != int64(http.StatusOK) {
+		t.Fatalf("Expecting status to be 200, but got %v", v)
+	}
+	if v, ok := service.stacks["_fetch.latency"].Front().(int64); !ok || v <= 0 {
+		t.Fatalf("Expecting latency to be measured, but got %v", v)
+	}
+	if v := service.Value("_fetch.bytes"); v != Format(int64(len(doc)), KindMemory) {
+		t.Fatalf("Expecting bytes to be formatted as memory, but got %q", v)
+	}
+	if v := service.Value("mem:memstats.Alloc"); v != "1.0KB" {
+		t.Fatalf("Expecting real vars to be fetched along with pseudo-vars, but got %q", v)
+	}
+
+	// size of gzipped response is counted after decoding
+	gzipped = true
+	update()
+	if v := service.stacks["_fetch.bytes"].Front(); v != int64(len(doc)) {
+		t.Fatalf("Expecting decompressed bytes to be %d, but got %v", len(doc), v)
+	}
+	gzipped = false
+
+	// full fetches of vars browser and profiles are not recorded
+	source, ok := untracked(service.Source)
+	if !ok {
+		t.Fatalf("Expecting HTTP source to be fetched out of polling")
+	}
+	if _, err := source.Fetch(nil); err != nil {
+		t.Fatal(err)
+	}
+	found = false
+	source.Fetch(nil)
+	if info, _ := service.Source.(responseReporter).LastResponse(); info.StatusCode != http.StatusOK || info.Bytes != int64(len(doc)) {
+		t.Fatalf("Expecting response of the last poll to be kept, but got %+v", info)
+	}
+
+	found = false
+	update()
+	if service.Err == nil {
+		t.Fatal("Expecting fetch to fail")
+	}
+	if v := service.Value("_fetch.status"); v != "404" {
+		t.Fatalf("Expecting status to be 404, but got %q", v)
+	}
+	if v := service.Value("_fetch.failures"); v != "1" {
+		t.Fatalf("Expecting failures to be 1, but got %q", v)
+	}
+	if v := service.Value("mem:memstats.Alloc"); v != "N/A" {
+		t.Fatalf("Expecting real vars to be N/A, but got %q", v)
+	}
+
+	// non-HTTP sources don't report responses
+	service.Source = errorSource{}
+	service.NextAttempt = time.Time{}
+	update()
+	if v := service.stacks["_fetch.status"].Front(); v != nil {
+		t.Fatalf("Expecting status to be unknown, but got %v", v)
+	}
+}
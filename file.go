@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antonholmquist/jason"
+)
+
+// FileScheme is URL scheme of expvar JSON dumps, like "file:///tmp/dump.json".
+const FileScheme = "file"
+
+// StdinTarget is a target for stream of newline-delimited
+// expvar JSON snapshots on stdin.
+const StdinTarget = "-"
+
+// IsStdin returns true if URL is a stdin target.
+func IsStdin(u url.URL) bool {
+	return u.Scheme == "" && u.Path == StdinTarget
+}
+
+// parseFileTarget parses file target, expanding glob patterns in path.
+func parseFileTarget(s string) ([]url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("no file path in %q", s)
+	}
+
+	paths := []string{u.Path}
+	if strings.ContainsAny(u.Path, "*?[") {
+		paths, err = filepath.Glob(u.Path)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no files found for %s", u.Path)
+		}
+	}
+
+	var urls []url.URL
+	for _, path := range paths {
+		urls = append(urls, url.URL{Scheme: FileScheme, Path: path})
+	}
+	return urls, nil
+}
+
+// FileSource reads expvar JSON from the file. File is re-read
+// on every fetch, so it can be overwritten by external tool.
+type FileSource struct {
+	Path string
+}
+
+// Fetch implements Source.
+func (s FileSource) Fetch([]VarName) (*Expvar, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return &Expvar{&jason.Object{}}, err
+	}
+	defer f.Close()
+	expvar, err := ParseExpvar(f)
+	if err != nil {
+		return &Expvar{&jason.Object{}}, fmt.Errorf("invalid file %s: %v", s.Path, err)
+	}
+	return expvar, nil
+}
+
+// snapshot is expvar data or error, read from the stream.
+type snapshot struct {
+	expvar *Expvar
+	err    error
+}
+
+// StreamSource reads newline-delimited expvar JSON snapshots
+// from reader. Each fetch takes the next snapshot; if there are
+// no new snapshots yet, or stream is over, the last one is returned.
+type StreamSource struct {
+	snapshots chan snapshot
+
+	mu   sync.Mutex
+	last *Expvar
+}
+
+// NewStreamSource returns new StreamSource, reading from r.
+func NewStreamSource(r io.Reader) *StreamSource {
+	s := &StreamSource{
+		snapshots: make(chan snapshot, 1024),
+	}
+	go s.read(r)
+	return s
+}
+
+func (s *StreamSource) read(r io.Reader) {
+	defer close(s.snapshots)
+
+	// bufio.Reader, as snapshots may exceed Scanner's line limit
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			expvar, perr := ParseExpvar(bytes.NewReader(line))
+			if perr != nil {
+				perr = fmt.Errorf("invalid snapshot: %v", perr)
+			}
+			s.snapshots <- snapshot{expvar, perr}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Fetch implements Source.
+func (s *StreamSource) Fetch([]VarName) (*Expvar, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snap, ok := s.next(); ok {
+		if snap.err != nil {
+			return &Expvar{&jason.Object{}}, snap.err
+		}
+		s.last = snap.expvar
+	}
+	if s.last == nil {
+		return &Expvar{&jason.Object{}}, errors.New("waiting for data")
+	}
+	return s.last, nil
+}
+
+// firstSnapshotWait is a time to wait for the first snapshot.
+const firstSnapshotWait = 200 * time.Millisecond
+
+// next returns the next snapshot, if any. Before the first snapshot
+// is received, it waits a moment for the stream to deliver it.
+func (s *StreamSource) next() (snapshot, bool) {
+	select {
+	case snap, ok := <-s.snapshots:
+		return snap, ok
+	default:
+	}
+	if s.last != nil {
+		return snapshot{}, false
+	}
+
+	select {
+	case snap, ok := <-s.snapshots:
+		return snap, ok
+	case <-time.After(firstSnapshotWait):
+		return snapshot{}, false
+	}
+}
+
+var (
+	stdinOnce   sync.Once
+	stdinSource *StreamSource
+)
+
+// StdinSource returns source, reading snapshots from stdin.
+// Stdin can be read only once, so source is shared.
+func StdinSource() *StreamSource {
+	stdinOnce.Do(func() {
+		stdinSource = NewStreamSource(os.Stdin)
+	})
+	return stdinSource
+}
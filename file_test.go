@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "dump.json")
+	ioutil.WriteFile(dump, []byte(`{"cmdline": ["app"], "memstats": {"Alloc": 1024, "PauseTotalNs": 1}}`), 0600)
+
+	urls, err := ParsePorts("file://" + dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewService(urls[0], []VarName{"mem:memstats.Alloc"})
+	if service.Name != "dump.json" {
+		t.Fatalf("Expecting file name to be used as service name, but got %q", service.Name)
+	}
+
+	// file is re-read on every tick
+	var wg sync.WaitGroup
+	for _, alloc := range []string{"1024", "2048"} {
+		ioutil.WriteFile(dump, []byte(`{"cmdline": ["app"], "memstats": {"Alloc": `+alloc+`, "PauseTotalNs": 1}}`), 0600)
+		wg.Add(1)
+		service.Update(&wg)
+	}
+	stack := service.stacks["mem:memstats.Alloc"]
+	if service.Err != nil || stack.Front() != int64(2048) || stack.Max != int64(2048) {
+		t.Fatalf("Expecting the last value and max to be 2048, but got %v, %v (%v)", stack.Front(), stack.Max, service.Err)
+	}
+
+	ioutil.WriteFile(dump, []byte(`{"memstats": `), 0600)
+	if _, err := service.Source.Fetch(nil); err == nil || !strings.Contains(err.Error(), "invalid file") {
+		t.Fatalf("Expecting truncated file to fail, but got %v", err)
+	}
+
+	if _, err := ParsePorts("file://" + dir + "/*.nope"); err == nil {
+		t.Fatalf("Expecting glob without matches to fail")
+	}
+}
+
+func TestStreamSource(t *testing.T) {
+	src := NewStreamSource(strings.NewReader(`{"goroutines": 1}
+
+{"goroutines": 2}
+not json
+{"goroutines": 3}`))
+
+	// wait for reader to queue all snapshots
+	for i := 0; len(src.snapshots) < 4 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	var got []string
+	for i := 0; i < 5; i++ {
+		expvar, err := src.Fetch(nil)
+		if err != nil {
+			got = append(got, "err")
+			continue
+		}
+		v, _ := expvar.GetInt64("goroutines")
+		got = append(got, string(rune('0'+v)))
+	}
+	// after the end of stream the last snapshot is kept
+	if strings.Join(got, ",") != "1,2,err,3,3" {
+		t.Fatalf("Expecting snapshots 1,2,err,3,3, but got %v", got)
+	}
+}
@@ -1,10 +1,12 @@
 module github.com/divan/expvarmon
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/antonholmquist/jason v1.0.0
 	github.com/bsiegert/ranges v0.0.0-20111221115336-19303dc7aa63
 	github.com/gizak/termui v0.0.0-20181228210747-b136f68f55f1
 	github.com/mattn/go-runewidth v0.0.4 // indirect
 	github.com/mitchellh/go-wordwrap v1.0.0 // indirect
 	github.com/pyk/byten v0.0.0-20140925233358-f847a130bf6d
+	gopkg.in/yaml.v3 v3.0.1
 )
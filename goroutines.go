@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// goroutineGrowthSamples is a number of samples in a row with growing
+// count after which goroutine stack is highlighted as leaking.
+const goroutineGrowthSamples = 3
+
+// GoroutineStack is a group of goroutines with the same stack.
+type GoroutineStack struct {
+	Count int
+	// Funcs are function names of stack frames, innermost first.
+	Funcs []string
+
+	// Delta is a change of count since the previous sample, and
+	// Growth is a number of samples in a row count was growing.
+	Delta  int
+	Growth int
+}
+
+// Key returns key, identifying the stack between samples.
+func (s GoroutineStack) Key() string {
+	return strings.Join(s.Funcs, "\n")
+}
+
+// Growing returns true if count of goroutines keeps growing.
+func (s GoroutineStack) Growing() bool {
+	return s.Growth >= goroutineGrowthSamples
+}
+
+// Summary returns short description of the stack: the innermost
+// frames, except for runtime internals (gopark, select, etc).
+func (s GoroutineStack) Summary() string {
+	funcs := s.Funcs
+	for len(funcs) > 1 && strings.HasPrefix(funcs[0], "runtime.") {
+		funcs = funcs[1:]
+	}
+	if len(funcs) > 3 {
+		funcs = funcs[:3]
+	}
+	return strings.Join(funcs, " < ")
+}
+
+// ParseGoroutines parses goroutine profile in text format
+// (/debug/pprof/goroutine?debug=1), where goroutines are
+// already grouped by stack.
+func ParseGoroutines(r io.Reader) ([]GoroutineStack, error) {
+	var stacks []GoroutineStack
+	var header bool
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "goroutine profile:"):
+			header = true
+		case strings.HasPrefix(line, "#"):
+			// "#	0x4699f1	net/http.(*conn).serve+0x5d1	/src/net/http/server.go:1930"
+			if len(stacks) == 0 {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[1], "0x") {
+				// i.e. "# labels: {...}"
+				continue
+			}
+			name := fields[2]
+			if i := strings.LastIndex(name, "+0x"); i > 0 {
+				name = name[:i]
+			}
+			stack := &stacks[len(stacks)-1]
+			stack.Funcs = append(stack.Funcs, name)
+		case strings.Contains(line, " @ "):
+			// "2 @ 0x43c4a5 0x44d4c5 0x4699f1"
+			count, err := strconv.Atoi(line[:strings.Index(line, " @ ")])
+			if err != nil {
+				return nil, fmt.Errorf("invalid goroutine profile line: %q", line)
+			}
+			stacks = append(stacks, GoroutineStack{Count: count})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, errors.New("not a goroutine profile")
+	}
+	return stacks, nil
+}
+
+// Goroutines tracks goroutine stacks of the service between samples.
+type Goroutines struct {
+	// Top is a number of stacks to display.
+	Top int
+
+	mu     sync.Mutex
+	stacks []GoroutineStack
+	total  int
+	err    error
+	prev   map[string]GoroutineStack
+	busy   bool // sample is in progress
+
+	// client is reused between samples of the service at url
+	client *http.Client
+	url    url.URL
+}
+
+// NewGoroutines returns new Goroutines, displaying top N stacks.
+func NewGoroutines(top int) *Goroutines {
+	return &Goroutines{Top: top}
+}
+
+// Sample starts fetch in background, so slow pprof endpoint doesn't
+// delay polls, and notifies redraw when it's done. Sample is skipped if
+// the previous one is still in progress or service is backing off.
+func (g *Goroutines) Sample(service *Service, now time.Time, redraw chan<- struct{}) {
+	if now.Before(service.NextAttempt) {
+		return
+	}
+	g.mu.Lock()
+	if g.busy {
+		g.mu.Unlock()
+		return
+	}
+	g.busy = true
+	g.mu.Unlock()
+
+	// copy everything needed, as service is updated concurrently
+	u, opts := service.URL, service.Options
+	go func() {
+		g.fetch(u, opts)
+		g.mu.Lock()
+		g.busy = false
+		g.mu.Unlock()
+		notify(redraw)
+	}()
+}
+
+// fetch fetches goroutine profile of the service at u and updates stacks.
+func (g *Goroutines) fetch(u url.URL, opts FetchOptions) {
+	if g.client == nil || g.url != u {
+		g.client, g.err = NewHTTPClient(opts, u)
+		g.url = u
+		g.prev = nil
+	}
+	var stacks []GoroutineStack
+	err := g.err
+	if g.client != nil {
+		stacks, err = fetchGoroutines(g.client, u)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.err = err
+	if err == nil {
+		g.update(stacks)
+	}
+}
+
+// update calculates changes since the previous sample and
+// sorts stacks by count.
+func (g *Goroutines) update(stacks []GoroutineStack) {
+	cur := make(map[string]GoroutineStack, len(stacks))
+	g.total = 0
+	for _, stack := range stacks {
+		g.total += stack.Count
+		key := stack.Key()
+		// the same functions may be called from different lines
+		if s, ok := cur[key]; ok {
+			s.Count += stack.Count
+			stack = s
+		}
+		cur[key] = stack
+	}
+
+	g.stacks = g.stacks[:0]
+	for key, stack := range cur {
+		if prev, ok := g.prev[key]; ok {
+			stack.Delta = stack.Count - prev.Count
+			if stack.Delta > 0 {
+				stack.Growth = prev.Growth + 1
+			}
+		} else if g.prev != nil {
+			stack.Delta = stack.Count
+		}
+		cur[key] = stack
+		g.stacks = append(g.stacks, stack)
+	}
+	g.prev = cur
+
+	sort.Slice(g.stacks, func(i, j int) bool {
+		if g.stacks[i].Count != g.stacks[j].Count {
+			return g.stacks[i].Count > g.stacks[j].Count
+		}
+		return g.stacks[i].Key() < g.stacks[j].Key()
+	})
+}
+
+// Stacks returns top stacks by count, and total number of goroutines.
+func (g *Goroutines) Stacks() ([]GoroutineStack, int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stacks := g.stacks
+	if len(stacks) > g.Top {
+		stacks = stacks[:g.Top]
+	}
+	return append([]GoroutineStack{}, stacks...), g.total, g.err
+}
+
+// Lines returns formatted lines for the goroutines pane, with
+// growing stacks highlighted, and total number of goroutines.
+func (g *Goroutines) Lines() ([]string, int) {
+	stacks, total, err := g.Stacks()
+	if err != nil {
+		return []string{alertText(err.Error())}, total
+	}
+	var lines []string
+	for _, stack := range stacks {
+		line := fmt.Sprintf("%6d %+5d  %s", stack.Count, stack.Delta, stack.Summary())
+		if stack.Growing() {
+			line = alertText(line)
+		}
+		lines = append(lines, line)
+	}
+	return lines, total
+}
+
+// fetchGoroutines fetches goroutine profile of the service at u.
+func fetchGoroutines(client *http.Client, u url.URL) ([]GoroutineStack, error) {
+	u, err := PprofURL(u, "goroutine", 0)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{"debug": {"1"}}.Encode()
+	resp, err := fetch(client, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("goroutine profile: %s", resp.Status)
+	}
+	return ParseGoroutines(resp.Body)
+}
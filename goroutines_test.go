@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// goroutineProfile returns goroutine profile in debug=1 format
+// with the given number of goroutines in handlers and workers.
+func goroutineProfile(handlers, workers int) string {
+	return fmt.Sprintf(`goroutine profile: total %d
+%d @ 0x43c4a5 0x44d4c5 0x4699f1 0x46a1e2
+# labels: {"handler":"api"}
+#	0x4699f0	runtime.gopark+0x10	/go/src/runtime/proc.go:398
+#	0x44d4c4	net/http.(*conn).serve+0x5d1	/go/src/net/http/server.go:1930
+#	0x46a1e1	runtime.goexit+0x1	/go/src/runtime/asm_amd64.s:1650
+
+%d @ 0x43c4a5 0x55d4c5 0x46a1e2
+#	0x4699f0	runtime.gopark+0x10	/go/src/runtime/proc.go:398
+#	0x55d4c4	main.worker+0x44	/app/main.go:42
+#	0x46a1e1	runtime.goexit+0x1	/go/src/runtime/asm_amd64.s:1650
+
+1 @ 0x43c4a5 0x55d4d5 0x46a1e2
+#	0x4699f0	runtime.gopark+0x10	/go/src/runtime/proc.go:398
+#	0x55d4d4	main.worker+0x55	/app/main.go:45
+#	0x46a1e1	runtime.goexit+0x1	/go/src/runtime/asm_amd64.s:1650
+`, handlers+workers+1, handlers, workers)
+}
+
+func TestParseGoroutines(t *testing.T) {
+	stacks, err := ParseGoroutines(strings.NewReader(goroutineProfile(2, 5)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stacks) != 3 {
+		t.Fatalf("Expecting 3 stacks, but got %d", len(stacks))
+	}
+	if stacks[0].Count != 2 || len(stacks[0].Funcs) != 3 {
+		t.Fatalf("Expecting first stack to have 2 goroutines and 3 frames, but got %+v", stacks[0])
+	}
+	if s := stacks[0].Summary(); s != "net/http.(*conn).serve < runtime.goexit" {
+		t.Fatalf("Expecting summary without runtime internals, but got %q", s)
+	}
+
+	if _, err := ParseGoroutines(strings.NewReader(`{"cmdline": []}`)); err == nil {
+		t.Fatal("Expecting non-profile to fail")
+	}
+}
+
+func TestGoroutines(t *testing.T) {
+	workers := 5
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != PprofEndpoint+"goroutine" || r.URL.Query().Get("debug") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(goroutineProfile(2, workers)))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL + "/debug/vars")
+	service := NewService(*u, nil)
+
+	g := NewGoroutines(2)
+	for i := 0; i <= goroutineGrowthSamples; i++ {
+		g.fetch(service.URL, service.Options)
+		workers += 10
+	}
+
+	stacks, total, err := g.Stacks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2+35+1 {
+		t.Fatalf("Expecting total to be 38, but got %d", total)
+	}
+	if len(stacks) != 2 {
+		t.Fatalf("Expecting top 2 stacks, but got %d", len(stacks))
+	}
+	// worker stacks from different lines are merged
+	if stacks[0].Count != 36 || stacks[0].Delta != 10 || !stacks[0].Growing() {
+		t.Fatalf("Expecting workers to be growing, but got %+v", stacks[0])
+	}
+	if stacks[1].Delta != 0 || stacks[1].Growing() {
+		t.Fatalf("Expecting handlers to be stable, but got %+v", stacks[1])
+	}
+
+	lines, _ := g.Lines()
+	if !strings.HasPrefix(lines[0], "[") || strings.HasPrefix(lines[1], "[") {
+		t.Fatalf("Expecting only growing stack to be highlighted, but got %q", lines)
+	}
+
+	// samples are taken in background, unless service is backing off
+	redraw := make(chan struct{}, 1)
+	service.NextAttempt = time.Now().Add(time.Minute)
+	g.Sample(service, time.Now(), redraw)
+	if g.busy {
+		t.Fatal("Expecting sample to be skipped while service is backing off")
+	}
+	service.NextAttempt = time.Time{}
+	g.Sample(service, time.Now(), redraw)
+	<-redraw
+	if _, total, _ := g.Stacks(); total != 2+workers+1 {
+		t.Fatalf("Expecting background sample to update stacks, but got total %d", total)
+	}
+
+	service.URL.Path = "/other"
+	service.URL.Host = "localhost:1"
+	g.fetch(service.URL, service.Options)
+	if _, _, err := g.Stacks(); err == nil {
+		t.Fatal("Expecting fetch from the service which is down to fail")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,27 +15,199 @@ import (
 var (
 	interval = flag.Duration("i", 5*time.Second, "Polling interval")
 	urls     = flag.String("ports", "", "Ports/URLs for accessing services expvars (start-end,port2,port3,https://host:port)")
-	varsArg  = flag.String("vars", "mem:memstats.Alloc,mem:memstats.Sys,mem:memstats.HeapAlloc,mem:memstats.HeapInuse,duration:memstats.PauseNs,duration:memstats.PauseTotalNs", "Vars to monitor (comma-separated)")
+	varsArg  = flag.String("vars", "mem:memstats.Alloc,mem:memstats.Sys,mem:memstats.HeapAlloc,mem:memstats.HeapInuse,duration:memstats.PauseNs,duration:memstats.PauseTotalNs", "Vars to monitor (comma-separated); modifiers are colon-separated, like scale:1e-6:unit:ms:memstats.PauseTotalNs (or scale:1e-6,unit:ms:memstats.PauseTotalNs)")
 	dummy    = flag.Bool("dummy", false, "Use dummy (console) output")
 	self     = flag.Bool("self", false, "Monitor itself")
 	endpoint = flag.String("endpoint", DefaultEndpoint, "URL endpoint for expvars")
+	config   = flag.String("config", "", "Config file (JSON, YAML or TOML) with services and vars to monitor")
+
+	record      = flag.String("record", "", "Append fetched data to the recording file")
+	recordVars  = flag.Bool("record-vars", false, "Record only monitored vars instead of raw JSON")
+	replay      = flag.String("replay", "", "Replay recording file instead of fetching data")
+	replaySpeed = flag.Float64("replay-speed", 1, "Replay speed factor")
+
+	alerts       = flag.String("alerts", "", "Alert rules (semicolon-separated), like 'memstats.HeapAlloc > 512MB for 30s'")
+	alertCmd     = flag.String("alert-cmd", "", "Shell command to run when alert triggers or resolves (JSON event on stdin)")
+	alertWebhook = flag.String("alert-webhook", "", "URL to POST JSON event to when alert triggers or resolves")
+
+	serve    = flag.String("serve", "", "Serve collected data as JSON (/debug/vars) and Prometheus metrics (/metrics) on the address")
+	headless = flag.Bool("headless", false, "Don't start terminal UI (use with -serve or -record)")
+
+	output = flag.String("output", "", "Non-interactive output format: csv, tsv or jsonl")
+	count  = flag.Int("count", 0, "Exit after N polls (0 means run forever)")
+
+	timeout    = flag.Duration("timeout", DefaultFetchOptions.Timeout, "Timeout of the single fetch")
+	retries    = flag.Int("retries", 0, "Number of retries of the failed fetch within single poll")
+	backoffMax = flag.Duration("backoff", DefaultFetchOptions.MaxBackoff, "Maximum backoff between polls of the service which is down")
+	slow       = flag.Duration("slow", 0, "Fetch duration after which service is marked as slow (default is half of -timeout)")
+
+	tlsCA         = flag.String("tls-ca", "", "PEM file with CA certificates to verify https services")
+	tlsCert       = flag.String("tls-cert", "", "PEM file with client certificate for mTLS")
+	tlsKey        = flag.String("tls-key", "", "PEM file with client certificate key for mTLS")
+	tlsServerName = flag.String("tls-server-name", "", "Server name for SNI and certificate verification")
+	tlsInsecure   = flag.Bool("tls-insecure", false, "Don't verify service certificates (insecure)")
+
+	token     = flag.String("token", "", "Bearer token to send to services")
+	tokenFile = flag.String("token-file", "", "File with bearer token, re-read on change")
+	netrc     = flag.String("netrc", "", ".netrc-style file to look up credentials by host (i.e. ~/.netrc)")
+	headers   headerFlags
+
+	commands stringsFlag
+
+	discover         = flag.String("discover", "", "Service discovery providers (comma-separated): file:PATH, srv:NAME, local")
+	discoverInterval = flag.Duration("discover-i", 10*time.Second, "Service discovery interval")
+
+	profileDir     = flag.String("profile-dir", "profiles", "Directory to save captured pprof profiles to")
+	profileSeconds = flag.Int("profile-seconds", 10, "Duration of captured CPU profile, in seconds")
+
+	maxSeries = flag.Int("max-series", 20, "Maximum number of vars, single wildcard var is expanded to")
+
+	goroutinesTop = flag.Int("goroutines", 0, "Show top N goroutine stacks of the service in single app mode (0 to disable)")
 )
 
 func main() {
+	flag.Var(&commands, "exec", "Shell command, printing expvar JSON, to run each poll (can be repeated)")
+	flag.Var(&headers, "header", "Extra header to send to services, as \"Name: value\" (can be repeated)")
 	flag.Usage = Usage
 	flag.Parse()
 
+	// Load config file, if specified. Flags set explicitly
+	// override values from the config.
+	cfg := &Config{}
+	if *config != "" {
+		var err error
+		cfg, err = LoadConfig(*config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid config file %s:\n%v\n", *config, err)
+			os.Exit(1)
+		}
+	}
+	isSet := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { isSet[f.Name] = true })
+
+	if cfg.Interval != 0 && !isSet["i"] {
+		*interval = time.Duration(cfg.Interval)
+	}
+	if cfg.Endpoint != "" && !isSet["endpoint"] {
+		*endpoint = cfg.Endpoint
+	}
 	DefaultEndpoint = *endpoint
 
+	// Process fetch options
+	DefaultFetchOptions = cfg.apply(DefaultFetchOptions)
+	if isSet["timeout"] {
+		DefaultFetchOptions.Timeout = *timeout
+	}
+	if isSet["retries"] {
+		DefaultFetchOptions.Retries = *retries
+	}
+	if isSet["backoff"] {
+		DefaultFetchOptions.MaxBackoff = *backoffMax
+	}
+	if isSet["slow"] {
+		DefaultFetchOptions.Slow = *slow
+	}
+	DefaultFetchOptions.TLS = DefaultFetchOptions.TLS.Merge(TLSOptions{
+		CAFile:     *tlsCA,
+		CertFile:   *tlsCert,
+		KeyFile:    *tlsKey,
+		ServerName: *tlsServerName,
+		Insecure:   *tlsInsecure,
+	})
+	if _, err := DefaultFetchOptions.TLS.Config(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *token != "" && *tokenFile != "" {
+		fmt.Fprintln(os.Stderr, "-token and -token-file can't be used together")
+		os.Exit(1)
+	}
+	DefaultFetchOptions.Auth = DefaultFetchOptions.Auth.Merge(AuthOptions{
+		Token:     *token,
+		TokenFile: *tokenFile,
+		Netrc:     *netrc,
+		Headers:   headers.Header,
+	})
+
+	// raw JSON recording needs whole documents
+	DefaultFetchOptions.Full = *record != "" && !*recordVars
+
+	// Process vars
+	varsStr := *varsArg
+	if len(cfg.Vars) > 0 && !isSet["vars"] {
+		varsStr = strings.Join(cfg.Vars, ",")
+	}
+	vars, err := ParseVars(varsStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Process ports/urls
-	ports, _ := ParsePorts(*urls)
-	if *self {
+	var services []*Service
+	var rep *Replay
+	if *replay != "" {
+		rep, err = LoadReplay(*replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rep.Speed = *replaySpeed
+		rep.Count = *count
+
+		urls, err := rep.URLs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, u := range urls {
+			service := NewService(u, vars)
+			service.Source = ReplaySource{service}
+			services = append(services, service)
+		}
+	} else if isSet["ports"] || len(commands) > 0 || len(cfg.Services) == 0 {
+		ports, err := ParsePorts(*urls)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			Usage()
+			os.Exit(1)
+		}
+		for _, port := range ports {
+			services = append(services, NewService(port, vars))
+		}
+		for _, command := range commands {
+			services = append(services, NewService(ExecURL(command), vars))
+		}
+	} else {
+		services, err = cfg.NewServices(vars)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vars = appendVars(vars, cfg.ServiceVars()...)
+	}
+	// Process discovery providers
+	var discovery *Discovery
+	if rep == nil {
+		discovery = &Discovery{Interval: *discoverInterval}
+		if cfg.DiscoverInterval != 0 && !isSet["discover-i"] {
+			discovery.Interval = time.Duration(cfg.DiscoverInterval)
+		}
+		discoverStr := strings.Join(cfg.Discover, ",")
+		if isSet["discover"] {
+			discoverStr = *discover
+		}
+		discovery.Discoverers, err = ParseDiscoverers(discoverStr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(discovery.Discoverers) == 0 {
+			discovery = nil
+		}
+	}
+	if *self && rep == nil {
 		port, err := StartSelfMonitor()
 		if err == nil {
-			ports = append(ports, port)
+			services = append(services, NewService(port, vars))
 		}
 	}
-	if len(ports) == 0 {
+	if len(services) == 0 && discovery == nil {
 		fmt.Fprintln(os.Stderr, "no ports specified. Use -ports arg to specify ports of Go apps to monitor")
 		Usage()
 		os.Exit(1)
@@ -44,29 +217,115 @@ func main() {
 		Usage()
 		os.Exit(1)
 	}
+	if *replaySpeed <= 0 {
+		fmt.Fprintln(os.Stderr, "replay speed should be positive")
+		Usage()
+		os.Exit(1)
+	}
+	if *profileSeconds <= 0 {
+		fmt.Fprintln(os.Stderr, "CPU profile duration should be positive")
+		Usage()
+		os.Exit(1)
+	}
 
-	// Process vars
-	vars, err := ParseVars(*varsArg)
-	if err != nil {
-		log.Fatal(err)
+	// Process alerts
+	rules := cfg.AlertRules()
+	if isSet["alerts"] {
+		rules, err = ParseAlertRules(*alerts)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// Init UIData
 	data := NewUIData(vars)
-	for _, port := range ports {
-		service := NewService(port, vars)
-		data.Services = append(data.Services, service)
+	data.Services = services
+	if len(rules) > 0 {
+		data.Alerts = NewAlerts(rules)
+		for _, service := range services {
+			for _, name := range data.Alerts.Vars() {
+				service.AddVar(name)
+			}
+		}
+
+		// don't fire hooks for the recorded alerts
+		if rep == nil {
+			data.Alerts.Command, data.Alerts.Webhook = cfg.AlertCommand, cfg.AlertWebhook
+			if isSet["alert-cmd"] {
+				data.Alerts.Command = *alertCmd
+			}
+			if isSet["alert-webhook"] {
+				data.Alerts.Webhook = *alertWebhook
+			}
+		}
+	}
+
+	// profiles can be captured from live services only
+	var profiles <-chan struct{}
+	if rep == nil {
+		dir, seconds := *profileDir, *profileSeconds
+		if cfg.ProfileDir != "" && !isSet["profile-dir"] {
+			dir = cfg.ProfileDir
+		}
+		if cfg.ProfileSeconds != 0 && !isSet["profile-seconds"] {
+			seconds = cfg.ProfileSeconds
+		}
+		data.Profiler = NewProfiler(dir, seconds)
+		profiles = data.Profiler.Progress
 	}
 
 	// Start proper UI
-	var ui UI
-	if len(data.Services) > 1 {
-		ui = &TermUI{}
-	} else {
-		ui = &TermUISingle{}
+	uiMode := cfg.UI
+	if isSet["dummy"] {
+		uiMode = ""
 	}
-	if *dummy {
-		ui = &DummyUI{}
+	if *headless {
+		uiMode = "headless"
+	}
+	var ui MultiUI
+	if *record != "" {
+		var recVars []VarName
+		if *recordVars {
+			recVars = vars
+		}
+		ui = append(ui, NewRecorder(*record, recVars))
+	}
+	serveAddr := *serve
+	if cfg.Serve != "" && !isSet["serve"] {
+		serveAddr = cfg.Serve
+	}
+	if serveAddr != "" {
+		ui = append(ui, NewExporter(serveAddr))
+	}
+	switch {
+	case uiMode == "headless":
+		if len(ui) == 0 {
+			fmt.Fprintln(os.Stderr, "headless mode requires -serve or -record to be specified")
+			Usage()
+			os.Exit(1)
+		}
+	case *output != "":
+		out, err := NewOutputUI(os.Stdout, *output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			Usage()
+			os.Exit(1)
+		}
+		ui = append(ui, out)
+	case *dummy || uiMode == "dummy":
+		ui = append(ui, &DummyUI{})
+	case uiMode == "single", uiMode == "" && len(data.Services) == 1 && discovery == nil:
+		top := *goroutinesTop
+		if cfg.Goroutines != 0 && !isSet["goroutines"] {
+			top = cfg.Goroutines
+		}
+		// goroutines are fetched from live services only
+		if top > 0 && rep == nil {
+			data.Goroutines = NewGoroutines(top)
+		}
+		ui = append(ui, &TermUISingle{})
+	default:
+		ui = append(ui, &TermUI{})
 	}
 
 	if err := ui.Init(*data); err != nil {
@@ -74,17 +333,50 @@ func main() {
 	}
 	defer ui.Close()
 
+	events := termui.PollEvents()
+	if rep != nil {
+		rep.Run(ui, data, events)
+		return
+	}
+
+	var discovered <-chan DiscoveryResult
+	if discovery != nil {
+		discovered = discovery.Run()
+	}
+
 	tick := time.NewTicker(*interval)
 
 	UpdateAll(ui, data)
-	for {
+	for polls := 1; *count <= 0 || polls < *count; {
 		select {
 		case <-tick.C:
 			UpdateAll(ui, data)
-		case e := <-termui.PollEvents():
+			polls++
+		case res := <-discovered:
+			vars := data.Patterns
+			if data.Alerts != nil {
+				vars = appendVars(vars, data.Alerts.Vars()...)
+			}
+			changed := SyncServices(data, res.URLs, vars, time.Now())
+			info := ""
+			if res.Err != nil {
+				info = fmt.Sprintf("(discovery: %v)", res.Err)
+			}
+			if changed || info != data.Info {
+				data.Info = info
+				ui.Update(*data)
+			}
+		case <-profiles:
+			ui.Update(*data)
+		case <-data.Redraw:
+			ui.Update(*data)
+		case e := <-events:
 			if e.Type == termui.KeyboardEvent && e.ID == "q" {
 				return
 			}
+			if e.Type == termui.KeyboardEvent && ui.HandleKey(e.ID, data) {
+				ui.Update(*data)
+			}
 			if e.Type == termui.ResizeEvent {
 				ui.Update(*data)
 			}
@@ -94,14 +386,27 @@ func main() {
 
 // UpdateAll collects data from expvars and refreshes UI.
 func UpdateAll(ui UI, data *UIData) {
+	// goroutines are sampled in background, not to delay polls
+	if data.Goroutines != nil && len(data.Services) > 0 {
+		data.Goroutines.Sample(data.Services[0], time.Now(), data.Redraw)
+	}
+
 	var wg sync.WaitGroup
 	for _, service := range data.Services {
+		// vanished services are kept only to be displayed
+		if !service.Vanished.IsZero() {
+			continue
+		}
 		wg.Add(1)
 		go service.Update(&wg)
 	}
 	wg.Wait()
 
+	data.ExpandVars()
 	data.LastTimestamp = time.Now()
+	if data.Alerts != nil {
+		data.Alerts.Check(data.Services, data.LastTimestamp)
+	}
 
 	ui.Update(*data)
 }
@@ -117,7 +422,20 @@ Examples:
 	%s -ports="23000-23010,http://example.com:80-81" -i=1m
 	%s -ports="80,remoteapp:80" -vars="mem:memstats.Alloc,duration:Response.Mean,Counter"
 	%s -ports="1234-1236" -vars="Goroutines" -self
+	%s -config="monitor.json"
+	%s -ports="1234" -alerts="mem:memstats.HeapAlloc > 512MB for 30s; down for 3 polls" -alert-cmd="notify-send expvarmon"
+	%s -ports="1234" -record="session.jsonl"
+	%s -replay="session.jsonl" -replay-speed=10
+	%s -ports="23000-23010" -serve=":9999" -headless
+	%s -ports="1234" -output=csv -count=10 -i=1s > stats.csv
+	%s -discover="local,file:targets.txt,srv:_expvar._tcp.example.com"
+	%s -exec="kubectl exec app -- curl -s localhost:1234/debug/vars" -timeout=5s
+	%s -ports="1234" -profile-dir="/tmp/profiles" -profile-seconds=30
+	%s -ports="1234" -vars="rate:http.requests.*,memstats.BySize[*].Mallocs" -max-series=10
+	%s -ports="1234" -vars="heap_util=expr:memstats.HeapInuse/memstats.HeapSys*100,live=expr:memstats.Mallocs-memstats.Frees"
+	%s -ports="1234" -vars="Heap=memiec:memstats.HeapAlloc,GC=scale:1e-6:unit:ms:memstats.PauseTotalNs,count:http.requests"
+	%s -ports="1234" -vars="max:duration:memstats.PauseNs,p99:duration:memstats.PauseNs"
 
 For more details and docs, see README: http://github.com/divan/expvarmon
-`, progname, progname, progname, progname)
+`, progname, progname, progname, progname, progname, progname, progname, progname, progname, progname, progname, progname, progname, progname, progname, progname, progname)
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// OutputFormats lists formats, supported by OutputUI.
+var OutputFormats = map[string]bool{
+	"csv":   true,
+	"tsv":   true,
+	"jsonl": true,
+}
+
+// OutputUI is a non-interactive UI implementation, which writes one
+// machine-readable record per service per update, for scripting.
+type OutputUI struct {
+	Format string
+
+	w    *bufio.Writer
+	csv  *csv.Writer
+	vars []VarName // vars in the last CSV header
+	last time.Time
+}
+
+// OutputRecord represents single record of jsonl output.
+type OutputRecord struct {
+	Time    time.Time            `json:"time"`
+	Service string               `json:"service"`
+	URL     string               `json:"url"`
+	Err     string               `json:"err,omitempty"`
+	Vars    map[string]OutputVar `json:"vars"`
+}
+
+// OutputVar represents raw and formatted value of the var.
+type OutputVar struct {
+	Value     VarValue `json:"value"`
+	Formatted string   `json:"formatted"`
+}
+
+// NewOutputUI returns new OutputUI, writing to w in the given format.
+func NewOutputUI(w io.Writer, format string) (*OutputUI, error) {
+	if !OutputFormats[format] {
+		return nil, fmt.Errorf("unknown output format %q, should be csv, tsv or jsonl", format)
+	}
+	o := &OutputUI{
+		Format: format,
+		w:      bufio.NewWriter(w),
+	}
+	if format != "jsonl" {
+		o.csv = csv.NewWriter(o.w)
+		if format == "tsv" {
+			o.csv.Comma = '\t'
+		}
+	}
+	return o, nil
+}
+
+// Init implements UI.
+func (o *OutputUI) Init(UIData) error { return nil }
+
+// Close implements UI.
+func (o *OutputUI) Close() {
+	o.w.Flush()
+}
+
+// Update implements UI.
+func (o *OutputUI) Update(data UIData) {
+	if !data.LastTimestamp.After(o.last) {
+		return
+	}
+	o.last = data.LastTimestamp
+
+	// vars may be added or removed (vars browser, wildcards),
+	// so new header is written before rows with new set of vars
+	if o.csv != nil && (o.vars == nil || !equalVars(o.vars, data.Vars)) {
+		o.vars = append([]VarName{}, data.Vars...)
+		header := []string{"time", "service", "url", "error"}
+		for _, name := range o.vars {
+			header = append(header, string(name), string(name)+" (formatted)")
+		}
+		o.csv.Write(header)
+	}
+
+	for _, service := range data.Services {
+		if o.csv != nil {
+			o.writeCSV(data, service)
+		} else {
+			o.writeJSON(data, service)
+		}
+	}
+	if o.csv != nil {
+		o.csv.Flush()
+	}
+	o.w.Flush()
+}
+
+func (o *OutputUI) writeCSV(data UIData, service *Service) {
+	var errStr string
+	if service.Err != nil {
+		errStr = service.Err.Error()
+	}
+	row := []string{
+		data.LastTimestamp.Format(time.RFC3339Nano),
+		service.Name,
+		RedactURL(service.URL),
+		errStr,
+	}
+	for _, name := range o.vars {
+		v := outputVar(service, name)
+		row = append(row, rawValue(v.Value), v.Formatted)
+	}
+	o.csv.Write(row)
+}
+
+func (o *OutputUI) writeJSON(data UIData, service *Service) {
+	rec := OutputRecord{
+		Time:    data.LastTimestamp,
+		Service: service.Name,
+		URL:     RedactURL(service.URL),
+		Vars:    make(map[string]OutputVar),
+	}
+	if service.Err != nil {
+		rec.Err = service.Err.Error()
+	}
+	for _, name := range data.Vars {
+		rec.Vars[string(name)] = outputVar(service, name)
+	}
+	b, _ := json.Marshal(rec)
+	o.w.Write(append(b, '\n'))
+}
+
+// outputVar returns the latest value of the var. Values are
+// empty for failed services, as in terminal UI.
+func outputVar(service *Service, name VarName) OutputVar {
+	stack, ok := service.stacks[name]
+	if !ok || service.Err != nil {
+		return OutputVar{}
+	}
+	v := stack.Front()
+	if v == nil {
+		return OutputVar{}
+	}
+	return OutputVar{Value: v, Formatted: name.Format(v)}
+}
+
+// rawValue formats raw value for CSV output.
+func rawValue(v VarValue) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antonholmquist/jason"
+)
+
+func newOutputTestData() *UIData {
+	vars := []VarName{"mem:memstats.Alloc", "goroutines"}
+	ok, failed := NewService(NewURL("1234"), vars), NewService(NewURL("1235"), vars)
+	data := NewUIData(vars)
+	data.Services = []*Service{ok, failed}
+	data.LastTimestamp = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	obj, _ := jason.NewObjectFromBytes([]byte(`{"cmdline": ["app"], "memstats": {"Alloc": 2048, "PauseTotalNs": 1}, "goroutines": 10}`))
+	ok.update(&Expvar{obj}, nil, data.LastTimestamp)
+	failed.update(&Expvar{&jason.Object{}}, errors.New("connection refused"), data.LastTimestamp)
+	return data
+}
+
+func TestOutputCSV(t *testing.T) {
+	data := newOutputTestData()
+
+	var buf bytes.Buffer
+	o, err := NewOutputUI(&buf, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Update(*data)
+	// UI updates without new data shouldn't produce records
+	o.Update(*data)
+
+	want := `time,service,url,error,mem:memstats.Alloc,mem:memstats.Alloc (formatted),goroutines,goroutines (formatted)
+2026-01-02T03:04:05Z,app,http://localhost:1234/debug/vars,,2048,2.0KB,10,10
+2026-01-02T03:04:05Z,localhost:1235,http://localhost:1235/debug/vars,connection refused,,,,
+`
+	if buf.String() != want {
+		t.Fatalf("Expecting CSV output:\n%s\nbut got:\n%s", want, buf.String())
+	}
+
+	// new header is written, when vars change
+	buf.Reset()
+	data.Vars = data.Vars[1:]
+	data.LastTimestamp = data.LastTimestamp.Add(time.Second)
+	o.Update(*data)
+	o.Update(*data)
+
+	want = `time,service,url,error,goroutines,goroutines (formatted)
+2026-01-02T03:04:06Z,app,http://localhost:1234/debug/vars,,10,10
+2026-01-02T03:04:06Z,localhost:1235,http://localhost:1235/debug/vars,connection refused,,
+`
+	if buf.String() != want {
+		t.Fatalf("Expecting CSV output with new header:\n%s\nbut got:\n%s", want, buf.String())
+	}
+}
+
+func TestOutputJSONL(t *testing.T) {
+	data := newOutputTestData()
+
+	var buf bytes.Buffer
+	o, err := NewOutputUI(&buf, "jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Update(*data)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expecting 2 records, but got %d", len(lines))
+	}
+	var rec OutputRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	alloc := rec.Vars["mem:memstats.Alloc"]
+	if rec.Service != "app" || alloc.Value != 2048.0 || alloc.Formatted != "2.0KB" {
+		t.Fatalf("Wrong record: %+v", rec)
+	}
+
+	if _, err := NewOutputUI(&buf, "xml"); err == nil {
+		t.Fatalf("Expecting unknown format to fail")
+	}
+}
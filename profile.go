@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PprofEndpoint is the URL endpoint of pprof handlers.
+const PprofEndpoint = "/debug/pprof/"
+
+// Profile describes pprof profile to capture.
+type Profile struct {
+	Name string // name of the pprof handler
+	File string // name of the file to save profile to
+}
+
+// Profiles lists profiles captured by Profiler, in order of capture.
+// CPU profile takes a while, so it goes last.
+var Profiles = []Profile{
+	{"heap", "heap.pb.gz"},
+	{"goroutine", "goroutine.pb.gz"},
+	{"mutex", "mutex.pb.gz"},
+	{"block", "block.pb.gz"},
+	{"profile", "cpu.pb.gz"},
+}
+
+// Profiler captures pprof profiles from services in background.
+//
+// Profiles are saved into timestamped directory, like
+// "profiles/myapp-20261018-150405", along with the snapshot of
+// expvar data (vars.json). Status describes progress of the last
+// capture and is displayed by UI.
+type Profiler struct {
+	Dir     string
+	Seconds int
+
+	// Progress receives notification on every status change,
+	// so UI can be redrawn.
+	Progress chan struct{}
+
+	mu     sync.Mutex
+	status string
+	busy   bool
+}
+
+// NewProfiler returns new Profiler, saving profiles into dir
+// and capturing CPU profile for the given number of seconds.
+func NewProfiler(dir string, seconds int) *Profiler {
+	return &Profiler{
+		Dir:      dir,
+		Seconds:  seconds,
+		Progress: make(chan struct{}, 1),
+	}
+}
+
+// Status returns status of the last capture.
+func (p *Profiler) Status() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+func (p *Profiler) setStatus(format string, args ...interface{}) {
+	p.mu.Lock()
+	p.status = fmt.Sprintf(format, args...)
+	p.mu.Unlock()
+
+	select {
+	case p.Progress <- struct{}{}:
+	default:
+	}
+}
+
+// Capture starts capture of profiles from the service in background.
+// It returns false if another capture is in progress.
+func (p *Profiler) Capture(service *Service) bool {
+	p.mu.Lock()
+	if p.busy {
+		p.mu.Unlock()
+		return false
+	}
+	p.busy = true
+	p.mu.Unlock()
+
+	// copy everything needed, as service is updated concurrently
+	name, u, opts, source := service.Name, service.URL, service.Options, service.Source
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.busy = false
+			p.mu.Unlock()
+		}()
+
+		dir, err := p.capture(name, u, opts, source, time.Now())
+		if err != nil {
+			p.setStatus("profiles of %s failed: %v", name, err)
+			return
+		}
+		p.setStatus("profiles of %s saved to %s", name, dir)
+	}()
+	return true
+}
+
+// capture captures all profiles from the service, returning directory
+// they were saved to. Failure of single profile (i.e. mutex and block
+// profiles may be disabled) doesn't stop capture of others.
+func (p *Profiler) capture(name string, u url.URL, opts FetchOptions, source Source, now time.Time) (string, error) {
+	if _, err := PprofURL(u, "heap", 0); err != nil {
+		return "", err
+	}
+
+	// CPU profile takes Seconds to capture
+	opts.Timeout += time.Duration(p.Seconds) * time.Second
+	client, err := NewHTTPClient(opts, u)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(p.Dir, fmt.Sprintf("%s-%s", safeFileName(name), now.Format("20060102-150405")))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	p.setStatus("saving vars of %s...", name)
+	var failed []string
+	if err := saveVars(source, filepath.Join(dir, "vars.json")); err != nil {
+		failed = append(failed, fmt.Sprintf("vars: %v", err))
+	}
+	varsFailed := len(failed)
+	for _, profile := range Profiles {
+		// other profiles are deltas if seconds are set
+		var seconds int
+		if profile.Name == "profile" {
+			seconds = p.Seconds
+			p.setStatus("capturing %ds CPU profile of %s...", seconds, name)
+		} else {
+			p.setStatus("capturing %s profile of %s...", profile.Name, name)
+		}
+		path := filepath.Join(dir, profile.File)
+		if err := saveProfile(client, u, profile.Name, seconds, path); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", profile.Name, err))
+		}
+	}
+	if len(failed)-varsFailed == len(Profiles) {
+		os.RemoveAll(dir)
+		return "", errors.New(failed[varsFailed])
+	}
+	if len(failed) > 0 {
+		return fmt.Sprintf("%s (failed %s)", dir, strings.Join(failed, "; ")), nil
+	}
+	return dir, nil
+}
+
+// saveVars fetches all expvar data from source and saves it to path.
+func saveVars(source Source, path string) error {
+	source, ok := untracked(source)
+	if !ok {
+		return errors.New("source can't be fetched out of polling")
+	}
+	expvar, err := source.Fetch(nil)
+	if err != nil {
+		return err
+	}
+	b, err := expvar.Marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// saveProfile fetches profile from service and saves it to path.
+func saveProfile(client *http.Client, u url.URL, name string, seconds int, path string) error {
+	pu, err := PprofURL(u, name, seconds)
+	if err != nil {
+		return err
+	}
+	resp, err := fetch(client, pu)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// PprofURL returns URL of the pprof profile on the same host as service
+// expvar URL. Seconds, if positive, is passed as profile duration.
+//
+// Pprof handlers are expected to be mounted next to expvar handler, so
+// "/app/debug/vars" has profiles at "/app/debug/pprof/"; PprofEndpoint
+// is used for endpoints outside of "/debug/".
+func PprofURL(u url.URL, name string, seconds int) (url.URL, error) {
+	if _, ok := ExecCommand(u); ok || u.Scheme == FileScheme || IsStdin(u) {
+		return url.URL{}, errors.New("profiles are available only for HTTP services")
+	}
+
+	var query string
+	if seconds > 0 {
+		query = fmt.Sprintf("seconds=%d", seconds)
+	}
+	u.Scheme = strings.TrimPrefix(u.Scheme, PrometheusSchemePrefix)
+	if socket, endpoint, ok := UnixSocket(u); ok {
+		pu := UnixURL(socket, pprofPath(endpoint)+name)
+		pu.RawQuery = query
+		return pu, nil
+	}
+	u.Path = pprofPath(u.Path) + name
+	u.RawQuery = query
+	return u, nil
+}
+
+// pprofPath returns path of pprof handlers for the expvar endpoint.
+func pprofPath(endpoint string) string {
+	if i := strings.LastIndex(endpoint, "/debug/"); i >= 0 {
+		return endpoint[:i] + PprofEndpoint
+	}
+	return PprofEndpoint
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// safeFileName returns name, safe to be used as file name.
+func safeFileName(name string) string {
+	name = strings.Trim(unsafeChars.ReplaceAllString(name, "_"), "_.")
+	if name == "" {
+		return "service"
+	}
+	return name
+}
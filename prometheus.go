@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antonholmquist/jason"
+)
+
+// PrometheusSchemePrefix marks URLs of services exposing
+// metrics in Prometheus text format, like "prom+http://host:9100/metrics".
+const PrometheusSchemePrefix = "prom+"
+
+// PrometheusEndpoint is the default url for fetching Prometheus metrics.
+const PrometheusEndpoint = "/metrics"
+
+// PrometheusSource fetches metrics in Prometheus text exposition format
+// and converts them into expvar data.
+type PrometheusSource struct {
+	URL    url.URL
+	Client *http.Client
+
+	*responseRecorder
+}
+
+// HasRuntimeVars implements runtimeReporter. Prometheus metrics
+// don't have memstats and cmdline.
+func (s PrometheusSource) HasRuntimeVars() bool {
+	return false
+}
+
+// Fetch implements Source.
+func (s PrometheusSource) Fetch(vars []VarName) (*Expvar, error) {
+	e := &Expvar{&jason.Object{}}
+	resp, err := fetch(s.Client, s.URL)
+	s.track(resp)
+	if err != nil {
+		return e, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return e, errors.New("Metrics not found. Is it Prometheus endpoint?")
+	}
+
+	metrics, err := ParsePrometheus(resp.Body)
+	if err != nil {
+		return e, err
+	}
+	return metrics.Expvar(vars)
+}
+
+// PrometheusSample represents single sample of the Prometheus metric.
+type PrometheusSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// PrometheusMetrics holds parsed Prometheus metrics.
+type PrometheusMetrics struct {
+	Samples []PrometheusSample
+	Types   map[string]string
+}
+
+// ParsePrometheus parses metrics in Prometheus text format from reader.
+func ParsePrometheus(r io.Reader) (*PrometheusMetrics, error) {
+	m := &PrometheusMetrics{
+		Types: make(map[string]string),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			fields := strings.Fields(text)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				m.Types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		sample, err := parsePrometheusSample(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		m.Samples = append(m.Samples, sample)
+	}
+	return m, scanner.Err()
+}
+
+// parsePrometheusSample parses sample line, like
+// `http_requests_total{method="post",code="200"} 1027 1395066363000`.
+func parsePrometheusSample(s string) (PrometheusSample, error) {
+	sample := PrometheusSample{Labels: make(map[string]string)}
+
+	end := strings.IndexAny(s, "{ \t")
+	if end == -1 {
+		return sample, fmt.Errorf("no value for metric %q", s)
+	}
+	sample.Name, s = s[:end], s[end:]
+
+	if strings.HasPrefix(s, "{") {
+		matchers, rest, err := parseLabels(s)
+		if err != nil {
+			return sample, err
+		}
+		for _, m := range matchers {
+			sample.Labels[m.Name] = m.Value
+		}
+		s = rest
+	}
+
+	// value, optionally followed by timestamp
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return sample, fmt.Errorf("no value for metric %q", sample.Name)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Errorf("invalid value for metric %q: %v", sample.Name, err)
+	}
+	sample.Value = v
+	return sample, nil
+}
+
+// labelMatcher represents label matcher in selector, like `code!="200"`.
+type labelMatcher struct {
+	Name  string
+	Op    string // one of "=", "!=", "=~", "!~"
+	Value string
+	re    *regexp.Regexp
+}
+
+func (m labelMatcher) matches(labels map[string]string) bool {
+	v := labels[m.Name]
+	switch m.Op {
+	case "!=":
+		return v != m.Value
+	case "=~":
+		return m.re.MatchString(v)
+	case "!~":
+		return !m.re.MatchString(v)
+	}
+	return v == m.Value
+}
+
+// parseLabels parses labels in curly braces, returning the rest of the string.
+func parseLabels(s string) ([]labelMatcher, string, error) {
+	var matchers []labelMatcher
+	s = strings.TrimPrefix(s, "{")
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if strings.HasPrefix(s, "}") {
+			return matchers, s[1:], nil
+		}
+
+		end := strings.IndexAny(s, "=!")
+		if end <= 0 {
+			return nil, "", fmt.Errorf("invalid labels at %q", s)
+		}
+		m := labelMatcher{Name: strings.TrimSpace(s[:end])}
+		s = s[end:]
+		for _, op := range []string{"=~", "!~", "!=", "="} {
+			if strings.HasPrefix(s, op) {
+				m.Op = op
+				break
+			}
+		}
+		if m.Op == "" {
+			return nil, "", fmt.Errorf("invalid label operator at %q", s)
+		}
+		s = strings.TrimLeft(s[len(m.Op):], " \t")
+
+		value, n, err := unquoteLabel(s)
+		if err != nil {
+			return nil, "", err
+		}
+		m.Value, s = value, s[n:]
+		if m.Op == "=~" || m.Op == "!~" {
+			m.re, err = regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		matchers = append(matchers, m)
+	}
+}
+
+// unquoteLabel unquotes label value, returning number of consumed bytes.
+func unquoteLabel(s string) (string, int, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", 0, fmt.Errorf("label value should be quoted at %q", s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated label value at %q", s)
+}
+
+// ParseSelector parses metric selector, like `http_requests_total{code="500"}`.
+func ParseSelector(s string) (string, []labelMatcher, error) {
+	idx := strings.IndexRune(s, '{')
+	if idx == -1 {
+		return s, nil, nil
+	}
+	matchers, rest, err := parseLabels(s[idx:])
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return "", nil, fmt.Errorf("unexpected %q after labels", rest)
+	}
+	return s[:idx], matchers, nil
+}
+
+// Value returns value for the selector, summing all matching series.
+//
+// For histograms, "quantile" label is calculated from buckets.
+func (m *PrometheusMetrics) Value(selector string) (float64, bool) {
+	name, matchers, err := ParseSelector(selector)
+	if err != nil {
+		return 0, false
+	}
+
+	var sum float64
+	var found bool
+	for _, sample := range m.Samples {
+		if sample.Name != name || !matchAll(matchers, sample.Labels) {
+			continue
+		}
+		sum += sample.Value
+		found = true
+	}
+	if found {
+		return sum, true
+	}
+
+	// histogram quantile, like `http_request_duration_seconds{quantile="0.99"}`
+	var rest []labelMatcher
+	q := math.NaN()
+	for _, matcher := range matchers {
+		if matcher.Name == "quantile" && matcher.Op == "=" {
+			q, err = strconv.ParseFloat(matcher.Value, 64)
+			if err != nil {
+				return 0, false
+			}
+			continue
+		}
+		rest = append(rest, matcher)
+	}
+	if math.IsNaN(q) {
+		return 0, false
+	}
+	return m.histogramQuantile(name, q, rest)
+}
+
+// histogramQuantile calculates quantile from histogram buckets,
+// the same way as Prometheus histogram_quantile() does.
+func (m *PrometheusMetrics) histogramQuantile(name string, q float64, matchers []labelMatcher) (float64, bool) {
+	counts := make(map[float64]float64)
+	for _, sample := range m.Samples {
+		if sample.Name != name+"_bucket" || !matchAll(matchers, sample.Labels) {
+			continue
+		}
+		le, err := strconv.ParseFloat(sample.Labels["le"], 64)
+		if err != nil {
+			continue
+		}
+		counts[le] += sample.Value
+	}
+	if len(counts) == 0 {
+		return 0, false
+	}
+
+	var bounds []float64
+	for le := range counts {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+
+	total := counts[bounds[len(bounds)-1]]
+	if total == 0 {
+		return 0, false
+	}
+	rank := q * total
+
+	var prevBound, prevCount float64
+	for i, le := range bounds {
+		count := counts[le]
+		if count >= rank {
+			if math.IsInf(le, 1) {
+				// quantile falls into +Inf bucket, return highest finite bound
+				if i > 0 {
+					return bounds[i-1], true
+				}
+				return 0, false
+			}
+			if count == prevCount {
+				return le, true
+			}
+			return prevBound + (le-prevBound)*(rank-prevCount)/(count-prevCount), true
+		}
+		prevBound, prevCount = le, count
+	}
+	return bounds[len(bounds)-1], true
+}
+
+func matchAll(matchers []labelMatcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// prometheusAliases maps standard Go client metrics to the expvar
+// memstats vars, so default vars work for Prometheus sources too.
+var prometheusAliases = map[string]string{
+	"memstats.Alloc":        "go_memstats_alloc_bytes",
+	"memstats.TotalAlloc":   "go_memstats_alloc_bytes_total",
+	"memstats.Sys":          "go_memstats_sys_bytes",
+	"memstats.Mallocs":      "go_memstats_mallocs_total",
+	"memstats.Frees":        "go_memstats_frees_total",
+	"memstats.HeapAlloc":    "go_memstats_heap_alloc_bytes",
+	"memstats.HeapSys":      "go_memstats_heap_sys_bytes",
+	"memstats.HeapInuse":    "go_memstats_heap_inuse_bytes",
+	"memstats.HeapObjects":  "go_memstats_heap_objects",
+	"memstats.NextGC":       "go_memstats_next_gc_bytes",
+	"memstats.PauseTotalNs": "go_gc_duration_seconds_sum",
+	"memstats.NumGC":        "go_gc_duration_seconds_count",
+	"Goroutines":            "go_goroutines",
+}
+
+// Expvar converts metrics into expvar data.
+//
+// Every series is available by its full name with sorted labels, and
+// by metric name alone (sum of all series). Vars with label matchers
+// and histogram quantiles are calculated for the given vars.
+func (m *PrometheusMetrics) Expvar(vars []VarName) (*Expvar, error) {
+	obj := make(map[string]interface{})
+	sums := make(map[string]float64)
+	for _, sample := range m.Samples {
+		v := sample.Value
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		obj[seriesName(sample)] = prometheusValue(sample.Name, v)
+
+		// sum of quantiles or buckets doesn't make sense
+		_, isQuantile := sample.Labels["quantile"]
+		_, isBucket := sample.Labels["le"]
+		if !isQuantile && !isBucket {
+			sums[sample.Name] += v
+		}
+	}
+	for name, v := range sums {
+		obj[name] = prometheusValue(name, v)
+	}
+
+	memstats := make(map[string]interface{})
+	for alias, name := range prometheusAliases {
+		v, ok := obj[name]
+		if !ok {
+			continue
+		}
+		path := strings.Split(alias, ".")
+		if len(path) == 1 {
+			obj[alias] = v
+			continue
+		}
+		memstats[path[1]] = v
+	}
+	if len(memstats) > 0 {
+		obj["memstats"] = memstats
+	}
+
+	for _, name := range vars {
+		slice := name.ToSlice()
+		if len(slice) != 1 {
+			continue
+		}
+		if _, ok := obj[slice[0]]; ok {
+			continue
+		}
+		if v, ok := m.Value(slice[0]); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			obj[slice[0]] = prometheusValue(slice[0], v)
+		}
+	}
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return &Expvar{&jason.Object{}}, err
+	}
+	o, err := jason.NewObjectFromBytes(b)
+	return &Expvar{o}, err
+}
+
+// seriesName returns series name with sorted labels,
+// like `http_requests_total{code="200",method="post"}`.
+func seriesName(s PrometheusSample) string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+	var keys []string
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var labels []string
+	for _, k := range keys {
+		labels = append(labels, fmt.Sprintf("%s=%q", k, s.Labels[k]))
+	}
+	return fmt.Sprintf("%s{%s}", s.Name, strings.Join(labels, ","))
+}
+
+// prometheusValue converts value to the form suitable for expvarmon kinds.
+//
+// By Prometheus conventions, values are in base units, so seconds
+// are converted into nanoseconds for "duration:" kind, and integer
+// values (counters, bytes) are converted to int64.
+func prometheusValue(selector string, v float64) interface{} {
+	name := selector
+	if idx := strings.IndexRune(name, '{'); idx != -1 {
+		name = name[:idx]
+	}
+	name = strings.TrimSuffix(name, "_total")
+	name = strings.TrimSuffix(name, "_sum")
+
+	if strings.HasSuffix(name, "_seconds") {
+		return int64(v * 1e9)
+	}
+	if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+		return int64(v)
+	}
+	return v
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const prometheusTestData = `# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 42
+# HELP go_gc_duration_seconds A summary of the GC invocation durations.
+# TYPE go_gc_duration_seconds summary
+go_gc_duration_seconds{quantile="0.5"} 0.000125
+go_gc_duration_seconds{quantile="1"} 0.0025
+go_gc_duration_seconds_sum 0.5
+go_gc_duration_seconds_count 40
+# TYPE go_memstats_alloc_bytes gauge
+go_memstats_alloc_bytes 1.048576e+06
+# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="200"} 3
+http_requests_total{method="get",code="500",path="/a.b"} 5
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.1"} 50
+http_request_duration_seconds_bucket{le="0.2"} 90
+http_request_duration_seconds_bucket{le="+Inf"} 100
+http_request_duration_seconds_sum 12.5
+http_request_duration_seconds_count 100
+`
+
+func TestPrometheus(t *testing.T) {
+	metrics, err := ParsePrometheus(strings.NewReader(prometheusTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.Samples) != 14 {
+		t.Fatalf("Expecting 14 samples, but got %d", len(metrics.Samples))
+	}
+
+	vars := []VarName{
+		`http_requests_total{code="200"}`,
+		`http_requests_total{path="/a.b"}`,
+		`http_requests_total{code=~"5.."}`,
+		`duration:http_request_duration_seconds{quantile="0.5"}`,
+		`duration:http_request_duration_seconds{quantile="0.95"}`,
+	}
+	expvar, err := metrics.Expvar(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name VarName
+		want int64
+	}{
+		{"go_goroutines", 42},
+		{"Goroutines", 42},
+		{"memstats.Alloc", 1048576},
+		{"memstats.PauseTotalNs", 500000000},
+		{"memstats.NumGC", 40},
+		{`go_gc_duration_seconds{quantile="1"}`, 2500000},
+		{"http_requests_total", 1035},
+		{`http_requests_total{code="200",method="get"}`, 1027},
+		{vars[0], 1030},
+		{vars[1], 5},
+		{vars[2], 5},
+		{vars[3], 100000000},
+		{vars[4], 200000000},
+	}
+	for _, test := range tests {
+		v, err := expvar.GetInt64(test.name.ToSlice()...)
+		if err != nil {
+			t.Fatalf("Cannot get %s: %v", test.name, err)
+		}
+		if v != test.want {
+			t.Fatalf("Expecting %s to be %d, but got %d", test.name, test.want, v)
+		}
+	}
+
+	if _, err := expvar.GetValue("go_gc_duration_seconds"); err == nil {
+		t.Fatalf("Summary quantiles shouldn't be summed up")
+	}
+
+	_, err = ParsePrometheus(strings.NewReader(`metric{label="value} 1`))
+	if err == nil {
+		t.Fatalf("Expecting error for unterminated label")
+	}
+}
+
+func TestPrometheusSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(prometheusTestData))
+	}))
+	defer srv.Close()
+
+	urls, err := ParsePorts("prom+" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0].Scheme != "prom+http" || urls[0].Path != "/metrics" {
+		t.Fatalf("ParsePorts returns wrong data: %v", urls)
+	}
+
+	service := NewService(urls[0], []VarName{"mem:memstats.Alloc", `http_requests_total{code="200"}`})
+	if _, ok := service.Source.(PrometheusSource); !ok {
+		t.Fatalf("Expecting Prometheus source, but got %T", service.Source)
+	}
+	expvar, err := service.Source.Fetch(service.vars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.update(expvar, err, time.Now())
+	if service.Err != nil || service.Value("mem:memstats.Alloc") != "1.0MB" || service.Value(`http_requests_total{code="200"}`) != "1030" {
+		t.Fatalf("Service updated incorrectly: %v, %s", service.Err, service.Value("mem:memstats.Alloc"))
+	}
+
+	// runtime vars are optional only for Prometheus sources
+	plain := NewService(url.URL{Host: "localhost:1234"}, service.vars())
+	plain.update(expvar, nil, time.Now())
+	if plain.Err == nil {
+		t.Fatal("Expecting error for expvar data without memstats and cmdline")
+	}
+}
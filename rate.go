@@ -0,0 +1,59 @@
+package main
+
+import "time"
+
+// Rate calculates per-second rate of change for the counter values.
+//
+// It's used for "rate:" vars, as most of the expvar values (like
+// memstats.NumGC or memstats.TotalAlloc) are monotonically increasing
+// counters, and raw values are not much useful for monitoring.
+type Rate struct {
+	// Signed rates are calculated for gauges, which may decrease,
+	// instead of treating decreases as counter resets.
+	Signed bool
+
+	last  float64
+	at    time.Time
+	valid bool
+}
+
+// Update records new counter value fetched at t and returns
+// rate since previous value, or nil if it cannot be calculated yet.
+func (r *Rate) Update(val VarValue, t time.Time) VarValue {
+	cur, ok := toFloat64(val)
+	if !ok {
+		r.valid = false
+		return nil
+	}
+
+	last, at, valid := r.last, r.at, r.valid
+	r.last, r.at, r.valid = cur, t, true
+	if !valid {
+		return nil
+	}
+
+	elapsed := t.Sub(at).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+
+	delta := cur - last
+	if delta < 0 && !r.Signed {
+		// counter has been reset (most probably, service
+		// was restarted), so assume it started from zero
+		delta = cur
+	}
+
+	return delta / elapsed
+}
+
+// toFloat64 converts numeric value to float64.
+func toFloat64(val VarValue) (float64, bool) {
+	switch v := val.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRate(t *testing.T) {
+	var r Rate
+	start := time.Now()
+
+	if v := r.Update(int64(100), start); v != nil {
+		t.Fatalf("Expecting first rate to be nil, but got %v", v)
+	}
+
+	v := r.Update(int64(200), start.Add(2*time.Second))
+	if v.(float64) != 50.0 {
+		t.Fatalf("Expecting rate to be %v, but got %v", 50.0, v)
+	}
+
+	v = r.Update(15.0, start.Add(2500*time.Millisecond))
+	if v.(float64) != 30.0 {
+		t.Fatalf("Expecting rate after counter reset to be %v, but got %v", 30.0, v)
+	}
+
+	if v = r.Update("string", start.Add(3*time.Second)); v != nil {
+		t.Fatalf("Expecting rate for non-numeric value to be nil, but got %v", v)
+	}
+	if v = r.Update(int64(20), start.Add(4*time.Second)); v != nil {
+		t.Fatalf("Expecting rate after non-numeric value to be nil, but got %v", v)
+	}
+
+	signed := Rate{Signed: true}
+	signed.Update(int64(100), start)
+	if v = signed.Update(int64(50), start.Add(time.Second)); v.(float64) != -50.0 {
+		t.Fatalf("Expecting signed rate to be %v, but got %v", -50.0, v)
+	}
+}
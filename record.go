@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Record represents single service snapshot in recording file.
+//
+// Recording file is a JSON-lines file, with one record per
+// service per update.
+type Record struct {
+	Time time.Time       `json:"t"`
+	URL  string          `json:"url"`
+	Err  string          `json:"err,omitempty"`
+	Vars json.RawMessage `json:"vars,omitempty"`
+}
+
+// Recorder is an UI implementation, which appends every fetched
+// expvar snapshot to the recording file.
+type Recorder struct {
+	Filename string
+	// Vars, if set, limits recorded data to the given vars only.
+	Vars []VarName
+
+	file *os.File
+	w    *bufio.Writer
+	last time.Time
+}
+
+// NewRecorder returns new Recorder for the given file. If vars
+// are specified, only those vars are recorded instead of raw JSON.
+func NewRecorder(filename string, vars []VarName) *Recorder {
+	return &Recorder{
+		Filename: filename,
+		Vars:     vars,
+	}
+}
+
+// Init implements UI.
+func (r *Recorder) Init(UIData) error {
+	file, err := os.OpenFile(r.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	r.w = bufio.NewWriter(file)
+	return nil
+}
+
+// Close implements UI.
+func (r *Recorder) Close() {
+	r.w.Flush()
+	r.file.Close()
+}
+
+// Update implements UI.
+func (r *Recorder) Update(data UIData) {
+	// UI may be updated without fetching new data (on resize, for example)
+	if !data.LastTimestamp.After(r.last) {
+		return
+	}
+	r.last = data.LastTimestamp
+
+	enc := json.NewEncoder(r.w)
+	for _, service := range data.Services {
+		rec := Record{
+			Time: data.LastTimestamp,
+			URL:  RedactURL(service.URL),
+		}
+		if service.Err != nil {
+			rec.Err = service.Err.Error()
+		} else if service.Expvar != nil {
+			rec.Vars = r.snapshot(service.Expvar)
+		}
+		enc.Encode(rec)
+	}
+	r.w.Flush()
+}
+
+// snapshot returns JSON representation of expvar data.
+func (r *Recorder) snapshot(expvar *Expvar) json.RawMessage {
+	if len(r.Vars) == 0 {
+		b, _ := expvar.Marshal()
+		return b
+	}
+
+	// cmdline and uptime counter are needed to track service name and restarts
+	paths := [][]string{{"cmdline"}, uptimeCounter}
+	for _, name := range dataVars(r.Vars) {
+		paths = append(paths, staticPath(name.ToSlice()))
+	}
+
+	obj := make(map[string]interface{})
+	for _, path := range paths {
+		value, err := expvar.GetValue(path...)
+		if err != nil || len(path) == 0 {
+			continue
+		}
+		b, err := value.Marshal()
+		if err != nil {
+			continue
+		}
+
+		m := obj
+		for _, key := range path[:len(path)-1] {
+			next, ok := m[key].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				m[key] = next
+			}
+			m = next
+		}
+		m[path[len(path)-1]] = json.RawMessage(b)
+	}
+	b, _ := json.Marshal(obj)
+	return b
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expvarmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "session.jsonl")
+
+	file, err := os.Open(expvarsTestFile)
+	if err != nil {
+		t.Fatalf("cannot open test file %v", err)
+	}
+	defer file.Close()
+	expvar, err := ParseExpvar(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := []VarName{"mem:memstats.Alloc", "rate:memstats.NumGC"}
+	ok, failed := NewService(NewURL("1234"), vars), NewService(NewURL("1235"), vars)
+	data := NewUIData(vars)
+	data.Services = []*Service{ok, failed}
+
+	rec := NewRecorder(filename, vars)
+	if err := rec.Init(*data); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		ok.update(expvar, nil, now)
+		failed.update(expvar, errors.New("connection refused"), now)
+		data.LastTimestamp = now
+		rec.Update(*data)
+		// UI updates without new data shouldn't be recorded
+		rec.Update(*data)
+	}
+	rec.Close()
+
+	r, err := LoadReplay(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Frames) != 3 || len(r.Frames[0].Records) != 2 {
+		t.Fatalf("Expecting 3 frames with 2 records, but got %v", r.Frames)
+	}
+
+	urls, err := r.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 2 || urls[0].Host != "localhost:1234" {
+		t.Fatalf("Expecting 2 URLs, but got %v", urls)
+	}
+
+	data = NewUIData(vars)
+	for _, u := range urls {
+		data.Services = append(data.Services, NewService(u, vars))
+	}
+	for r.Next(data) {
+	}
+
+	service := data.Services[0]
+	if service.Name != "geo.service" || service.Value("mem:memstats.Alloc") != "299KB" {
+		t.Fatalf("Replayed service has wrong data: %s, %s", service.Name, service.Value("mem:memstats.Alloc"))
+	}
+	if service.Value("rate:memstats.NumGC") != "0.00/s" {
+		t.Fatalf("Expecting rate to be calculated, but got %s", service.Value("rate:memstats.NumGC"))
+	}
+	if data.Services[1].Err == nil || data.Services[1].Err.Error() != "connection refused" {
+		t.Fatalf("Expecting recorded error, but got %v", data.Services[1].Err)
+	}
+
+	r.Seek(data, 1)
+	if service.Value("rate:memstats.NumGC") != "N/A" || !data.LastTimestamp.Equal(start) {
+		t.Fatalf("Expecting seek to reset services, but got %s", service.Value("rate:memstats.NumGC"))
+	}
+	r.SeekTime(data, start.Add(1500*time.Millisecond))
+	if r.pos != 2 {
+		t.Fatalf("Expecting seek to the second frame, but got %d", r.pos)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reducers lists modifiers, which reduce array values to a single
+// value, like "max:memstats.PauseNs". Percentiles are "pNN:" modifiers,
+// like "p99:" or "p99.9:". Arrays are averaged, if reducer is not set.
+var reducers = map[string]bool{
+	"avg":  true,
+	"last": true,
+	"max":  true,
+	"min":  true,
+	"sum":  true,
+	"len":  true,
+}
+
+// isReducer returns true if modifier is array reducer.
+func isReducer(mod string) bool {
+	if reducers[mod] {
+		return true
+	}
+	_, ok := percentile(mod)
+	return ok
+}
+
+// percentile returns percentile of "pNN" reducer.
+func percentile(mod string) (float64, bool) {
+	if len(mod) < 2 || mod[0] != 'p' {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(mod[1:], 64)
+	if err != nil || p <= 0 || p > 100 {
+		return 0, false
+	}
+	return p, true
+}
+
+// Reducer returns array reducer modifier of the var, if any.
+func (v VarName) Reducer() string {
+	mods, _ := v.split()
+	for _, mod := range mods {
+		if isReducer(mod) {
+			return mod
+		}
+	}
+	return ""
+}
+
+// ringBuffers lists circular buffers of the Go runtime memstats,
+// with fields holding the number of values written to them. The
+// most recent value is at index (NumGC+255)%256.
+var ringBuffers = map[string]string{
+	"PauseNs":  "NumGC",
+	"PauseEnd": "NumGC",
+}
+
+// ringCounter returns var of the ring buffer counter for the var
+// ("memstats.NumGC" for "memstats.PauseNs"), if var is a ring buffer.
+func (v VarName) ringCounter() (VarName, bool) {
+	if v.Computed() {
+		return "", false
+	}
+	path := v.ToSlice()
+	if len(path) == 0 {
+		return "", false
+	}
+	counter, ok := ringBuffers[path[len(path)-1]]
+	if !ok {
+		return "", false
+	}
+	var keys []string
+	for _, s := range path[:len(path)-1] {
+		i := indexStart(s)
+		keys = append(keys, strings.Replace(s[:i], ".", `\.`, -1)+s[i:])
+	}
+	return VarName(strings.Join(append(keys, counter), ".")), true
+}
+
+// varValue returns value of the var in expvar data, reducing arrays
+// with var reducer. Ring buffers are ordered from the oldest value,
+// and only written values are used.
+func varValue(e *Expvar, name VarName) (VarValue, error) {
+	value, err := e.Lookup(name.ToSlice()...)
+	if err != nil {
+		return nil, err
+	}
+	arr, err := value.Array()
+	if err != nil {
+		return guessValue(value), nil
+	}
+
+	values := make([]float64, len(arr))
+	for i, v := range arr {
+		values[i], _ = v.Float64()
+	}
+	isInt := len(arr) > 0
+	if isInt {
+		_, err := arr[0].Int64()
+		isInt = err == nil
+	}
+
+	ring := false
+	if counter, ok := name.ringCounter(); ok {
+		if n, err := e.Lookup(counter.ToSlice()...); err == nil {
+			if n, err := n.Int64(); err == nil {
+				values, ring = ringOrder(values, n), true
+			}
+		}
+	}
+
+	reducer := name.Reducer()
+	if reducer == "" {
+		if !ring {
+			// trim unfilled values, see average
+			values = trimZeros(values)
+		}
+		if len(values) == 0 {
+			return int64(0), nil
+		}
+		reducer = "avg"
+	}
+	v, ok := reduce(values, reducer)
+	switch {
+	case !ok:
+		return nil, nil
+	case reducer == "len":
+		return int64(v), nil
+	case isInt:
+		return int64(v), nil
+	}
+	return v, nil
+}
+
+// ringOrder returns values of the ring buffer, which n values were
+// written to, ordered from the oldest to the most recent one.
+func ringOrder(values []float64, n int64) []float64 {
+	size := int64(len(values))
+	if size == 0 || n <= 0 {
+		return nil
+	}
+	count := n
+	if count > size {
+		count = size
+	}
+	ordered := make([]float64, count)
+	start := (n - count) % size
+	for i := range ordered {
+		ordered[i] = values[(start+int64(i))%size]
+	}
+	return ordered
+}
+
+// trimZeros trims zero values from the right.
+func trimZeros(values []float64) []float64 {
+	for i := len(values); i > 0; i-- {
+		if values[i-1] != 0 {
+			return values[:i]
+		}
+	}
+	return values[:0]
+}
+
+// reduce reduces values with reducer. It returns false if values
+// are empty, except for len and sum reducers.
+func reduce(values []float64, reducer string) (float64, bool) {
+	switch reducer {
+	case "len":
+		return float64(len(values)), true
+	case "sum":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum, true
+	}
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	switch reducer {
+	case "last":
+		return values[len(values)-1], true
+	case "max":
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max, true
+	case "min":
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min, true
+	case "avg":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values)), true
+	}
+
+	p, ok := percentile(reducer)
+	if !ok {
+		return 0, false
+	}
+	// nearest-rank method, so result is one of values
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1], true
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ring buffer of 4 values with 6 values written, so the oldest
+// value is 30 at index 2, and the most recent is 60 at index 1
+const reduceJSON = `{
+	"cmdline": ["app"],
+	"memstats": {"NumGC": 6, "PauseNs": [50, 60, 30, 40], "Alloc": 1024, "PauseTotalNs": 180},
+	"fresh": {"NumGC": 2, "PauseNs": [100, 300, 0, 0]},
+	"latencies": [5, 1, 0, 4, 3, 2, 0],
+	"ratios": [0.5, 0.25],
+	"empty": []
+}`
+
+func TestReduce(t *testing.T) {
+	values := []float64{5, 1, 9, 4, 3, 2, 8, 7, 6, 10}
+	tests := []struct {
+		reducer string
+		want    float64
+	}{
+		{"avg", 5.5},
+		{"last", 10},
+		{"max", 10},
+		{"min", 1},
+		{"sum", 55},
+		{"len", 10},
+		{"p50", 5},
+		{"p90", 9},
+		{"p99", 10},
+		{"p10", 1},
+	}
+	for _, test := range tests {
+		if got, ok := reduce(values, test.reducer); !ok || got != test.want {
+			t.Fatalf("Expecting %s to be %v, but got %v", test.reducer, test.want, got)
+		}
+	}
+	if _, ok := reduce(nil, "max"); ok {
+		t.Fatal("Expecting max of empty values to fail")
+	}
+	if got, ok := reduce(nil, "len"); !ok || got != 0 {
+		t.Fatalf("Expecting len of empty values to be 0, but got %v", got)
+	}
+
+	if got := ringOrder([]float64{50, 60, 30, 40}, 6); !reflect.DeepEqual(got, []float64{30, 40, 50, 60}) {
+		t.Fatalf("Expecting wrapped ring to be ordered from the oldest value, but got %v", got)
+	}
+	if got := ringOrder([]float64{100, 300, 0, 0}, 2); !reflect.DeepEqual(got, []float64{100, 300}) {
+		t.Fatalf("Expecting only written values, but got %v", got)
+	}
+
+	for _, mod := range []string{"p99", "p99.9", "max", "len"} {
+		if !isReducer(mod) {
+			t.Fatalf("Expecting %s to be reducer", mod)
+		}
+	}
+	for _, mod := range []string{"p0", "p101", "pp", "mem"} {
+		if isReducer(mod) {
+			t.Fatalf("Expecting %s not to be reducer", mod)
+		}
+	}
+}
+
+func TestServiceReducers(t *testing.T) {
+	vars := []VarName{
+		"last:memstats.PauseNs",
+		"max:memstats.PauseNs",
+		"p50:memstats.PauseNs",
+		"memstats.PauseNs",
+		"fresh.PauseNs",
+		"min:fresh.PauseNs",
+		"latencies",
+		"len:latencies",
+		"max:latencies",
+		"sum:ratios",
+		"p99:empty",
+		"empty",
+		"max:memstats.Alloc",
+		"rate:sum:latencies",
+	}
+	for _, name := range vars {
+		if err := name.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := VarName("p99:duration:memstats.PauseNs"); v.Reducer() != "p99" || v.Kind() != KindDuration || v.Long() != "memstats.PauseNs" {
+		t.Fatalf("Expecting reducer to be combined with kind, but got %q, %v, %q", v.Reducer(), v.Kind(), v.Long())
+	}
+
+	service := NewService(url.URL{Host: "localhost:1234"}, vars)
+	// ring counters are extracted along with buffers
+	extracted, err := ExtractExpvar(strings.NewReader(reduceJSON), service.vars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.update(extracted, nil, time.Now())
+
+	tests := []struct {
+		name VarName
+		want string
+	}{
+		{vars[0], "60"},
+		{vars[1], "60"},
+		{vars[2], "40"},
+		{vars[3], "45"},
+		{vars[4], "200"},
+		{vars[5], "100"},
+		{vars[6], "2"}, // trailing zero is trimmed
+		{vars[7], "7"},
+		{vars[8], "5"},
+		{vars[9], "0.75"},
+		{vars[10], "N/A"},
+		{vars[11], "0"},
+		{vars[12], "1024"},
+	}
+	for _, test := range tests {
+		if got := service.Value(test.name); got != test.want {
+			t.Fatalf("Expecting %s to be %q, but got %q", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/antonholmquist/jason"
+	"github.com/gizak/termui"
+)
+
+// Frame represents all service snapshots recorded at single update.
+type Frame struct {
+	Time    time.Time
+	Records []Record
+}
+
+// Replay plays recorded session back, driving services
+// from the recording instead of fetching data by HTTP.
+type Replay struct {
+	Frames []Frame
+	Speed  float64
+	Paused bool
+
+	// Count, if positive, stops playback after that many
+	// frames are played, like -count for live polls.
+	Count int
+
+	pos int // number of frames applied
+}
+
+// ReplaySource is a Source for replayed services, returning
+// data of the currently replayed frame instead of fetching it.
+type ReplaySource struct {
+	Service *Service
+}
+
+// Fetch implements Source.
+func (s ReplaySource) Fetch([]VarName) (*Expvar, error) {
+	if s.Service.Expvar == nil {
+		return nil, errors.New("no data replayed yet")
+	}
+	return s.Service.Expvar, s.Service.Err
+}
+
+// LoadReplay reads recording file, created by Recorder.
+func LoadReplay(filename string) (*Replay, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := &Replay{Speed: 1}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+
+		n := len(r.Frames)
+		if n == 0 || !r.Frames[n-1].Time.Equal(rec.Time) {
+			r.Frames = append(r.Frames, Frame{Time: rec.Time})
+			n++
+		}
+		r.Frames[n-1].Records = append(r.Frames[n-1].Records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.Frames) == 0 {
+		return nil, errors.New("recording is empty")
+	}
+	return r, nil
+}
+
+// URLs returns URLs of all recorded services, in order of appearance.
+func (r *Replay) URLs() ([]url.URL, error) {
+	var urls []url.URL
+	seen := make(map[string]bool)
+	for _, frame := range r.Frames {
+		for _, rec := range frame.Records {
+			if seen[rec.URL] {
+				continue
+			}
+			seen[rec.URL] = true
+
+			u, err := url.Parse(rec.URL)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, *u)
+		}
+	}
+	return urls, nil
+}
+
+// Next applies next frame to services. It returns false if
+// the end of recording is reached.
+func (r *Replay) Next(data *UIData) bool {
+	if r.pos >= len(r.Frames) {
+		return false
+	}
+
+	frame := r.Frames[r.pos]
+	services := make(map[string]*Service)
+	for _, service := range data.Services {
+		services[service.URL.String()] = service
+	}
+	for _, rec := range frame.Records {
+		service, ok := services[rec.URL]
+		if !ok {
+			continue
+		}
+
+		var err error
+		expvar := &Expvar{&jason.Object{}}
+		if rec.Err != "" {
+			err = errors.New(rec.Err)
+		} else if e, perr := ParseExpvar(bytes.NewReader(rec.Vars)); perr == nil {
+			expvar = e
+		} else {
+			err = perr
+		}
+		service.update(expvar, err, frame.Time)
+	}
+	data.ExpandVars()
+
+	data.LastTimestamp = frame.Time
+	if data.Alerts != nil {
+		data.Alerts.Check(data.Services, data.LastTimestamp)
+	}
+	r.pos++
+	return true
+}
+
+// Seek rewinds recording to the given frame position, resetting
+// services and replaying all frames before it.
+func (r *Replay) Seek(data *UIData, pos int) {
+	if pos < 1 {
+		pos = 1
+	}
+	if pos > len(r.Frames) {
+		pos = len(r.Frames)
+	}
+
+	if pos < r.pos {
+		for _, service := range data.Services {
+			service.Reset()
+		}
+		if data.Alerts != nil {
+			data.Alerts.Reset()
+		}
+		r.pos = 0
+	}
+	for r.pos < pos {
+		r.Next(data)
+	}
+}
+
+// SeekTime rewinds recording to the last frame recorded at or before t.
+func (r *Replay) SeekTime(data *UIData, t time.Time) {
+	pos := sort.Search(len(r.Frames), func(i int) bool {
+		return r.Frames[i].Time.After(t)
+	})
+	r.Seek(data, pos)
+}
+
+// Delay returns time to wait before the next frame, according to speed.
+func (r *Replay) Delay() time.Duration {
+	if r.pos == 0 || r.pos >= len(r.Frames) {
+		return 0
+	}
+	d := r.Frames[r.pos].Time.Sub(r.Frames[r.pos-1].Time)
+	return time.Duration(float64(d) / r.Speed)
+}
+
+// Status returns human-readable replay position.
+func (r *Replay) Status() string {
+	state := "playing"
+	if r.Paused {
+		state = "paused"
+	} else if r.pos >= len(r.Frames) {
+		state = "finished"
+	}
+	return fmt.Sprintf("replay %d/%d x%g %s", r.pos, len(r.Frames), r.Speed, state)
+}
+
+// Run plays recording back, handling keyboard controls, until user quits.
+//
+// Keys: space - pause/resume, left/right - step back/forward,
+// +/- - change speed, home/end and 0-9 - jump to start, end
+// or 0%-90% of recording time. While vars browser or zoom view is
+// shown, keys are passed to the UI first.
+func (r *Replay) Run(ui UI, data *UIData, events <-chan termui.Event) {
+	update := func() {
+		data.Info = r.Status()
+		ui.Update(*data)
+	}
+
+	next := time.After(0)
+	played := 0
+	for {
+		select {
+		case <-next:
+			next = nil
+			ok := r.Next(data)
+			if ok && !r.Paused {
+				next = time.After(r.Delay())
+			}
+			update()
+			if ok {
+				played++
+			}
+			if r.Count > 0 && played >= r.Count {
+				return
+			}
+		case <-data.Redraw:
+			ui.Update(*data)
+		case e := <-events:
+			if e.Type == termui.ResizeEvent {
+				ui.Update(*data)
+				continue
+			}
+			if e.Type != termui.KeyboardEvent {
+				continue
+			}
+			// overlays take keys over replay controls
+			h, _ := ui.(KeyHandler)
+			o, overlay := ui.(Overlayer)
+			overlay = overlay && o.Overlay() && e.ID != "q"
+			if overlay && h != nil && h.HandleKey(e.ID, data) {
+				ui.Update(*data)
+				continue
+			}
+
+			switch e.ID {
+			case "q":
+				return
+			case "<Space>":
+				r.Paused = !r.Paused
+			case "<Right>":
+				r.Paused = true
+				r.Next(data)
+			case "<Left>":
+				r.Paused = true
+				r.Seek(data, r.pos-1)
+			case "+":
+				r.Speed *= 2
+			case "-":
+				r.Speed /= 2
+			case "<Home>":
+				r.Seek(data, 1)
+			case "<End>":
+				r.Seek(data, len(r.Frames))
+			case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9":
+				first, last := r.Frames[0].Time, r.Frames[len(r.Frames)-1].Time
+				part := time.Duration(e.ID[0]-'0') * last.Sub(first) / 10
+				r.SeekTime(data, first.Add(part))
+			default:
+				if !overlay && h != nil && h.HandleKey(e.ID, data) {
+					ui.Update(*data)
+				}
+				continue
+			}
+
+			next = nil
+			if !r.Paused {
+				next = time.After(r.Delay())
+			}
+			update()
+		}
+	}
+}
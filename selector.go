@@ -0,0 +1,518 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antonholmquist/jason"
+)
+
+// Array indexes in var paths follow the key in square brackets, and
+// can be chained, like "matrix[0][1]":
+//
+//	[3]                 element by position
+//	[-1]                element from the end (the last one)
+//	[*]                 all elements (see VarName.Wildcard)
+//	[(NumGC+255)%256]   arithmetic expression (+ - * / % and parens)
+//	                    over numeric fields of the object holding array
+//	[?Size==1024]       the first element with fields matching condition
+//	                    (== != < <= > >=, joined with &&), compared to
+//	                    numbers, "strings", true, false or null
+//
+// Literal brackets in keys are escaped with backslash ("\[").
+
+// index is a parsed array index.
+type index struct {
+	raw      string
+	wildcard bool
+	expr     expr
+	filter   []predicate
+}
+
+// indexes caches parsed indexes, as the same vars are resolved on every poll.
+var indexes sync.Map
+
+// parseIndex parses index in brackets (without them).
+func parseIndex(s string) (index, error) {
+	if ix, ok := indexes.Load(s); ok {
+		return ix.(index), nil
+	}
+
+	ix := index{raw: s}
+	p := &exprParser{s: s}
+	p.skipSpace()
+	switch {
+	case p.eof():
+		return ix, errors.New("empty index")
+	case strings.TrimSpace(s) == "*":
+		ix.wildcard = true
+		p.pos = len(s)
+	case p.peek() == '?':
+		p.pos++
+		filter, err := p.filter()
+		if err != nil {
+			return ix, err
+		}
+		ix.filter = filter
+	default:
+		e, err := p.expr()
+		if err != nil {
+			return ix, err
+		}
+		ix.expr = e
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return ix, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+
+	indexes.Store(s, ix)
+	return ix, nil
+}
+
+// String returns index in brackets.
+func (ix index) String() string {
+	return "[" + ix.raw + "]"
+}
+
+// resolve returns position of the element in array. Parent is an object,
+// holding the array, to resolve fields used in expressions.
+func (ix index) resolve(arr []*jason.Value, parent *jason.Object) (int, error) {
+	if ix.filter != nil {
+		for i, v := range arr {
+			if obj, err := v.Object(); err == nil && matchFilter(ix.filter, obj) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no elements match %s", ix)
+	}
+
+	n, err := ix.expr.eval(parent)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		n += int64(len(arr))
+	}
+	if n < 0 || n >= int64(len(arr)) {
+		return 0, fmt.Errorf("index %s out of range (length %d)", ix, len(arr))
+	}
+	return int(n), nil
+}
+
+// usesFields returns true if index expression refers to fields
+// of the object, holding the array.
+func (ix index) usesFields() bool {
+	return ix.expr != nil && exprUsesFields(ix.expr)
+}
+
+func exprUsesFields(e expr) bool {
+	switch e := e.(type) {
+	case fieldExpr:
+		return true
+	case negExpr:
+		return exprUsesFields(e.x)
+	case binaryExpr:
+		return exprUsesFields(e.x) || exprUsesFields(e.y)
+	}
+	return false
+}
+
+// expr is an arithmetic expression of the index.
+type expr interface {
+	eval(obj *jason.Object) (int64, error)
+}
+
+type numberExpr int64
+
+func (e numberExpr) eval(*jason.Object) (int64, error) { return int64(e), nil }
+
+// fieldExpr is a numeric field of the object, may be dotted.
+type fieldExpr []string
+
+func (e fieldExpr) eval(obj *jason.Object) (int64, error) {
+	if obj == nil {
+		return 0, fmt.Errorf("no field %s", strings.Join(e, "."))
+	}
+	v, err := obj.GetValue(e...)
+	if err != nil {
+		return 0, fmt.Errorf("no field %s", strings.Join(e, "."))
+	}
+	if n, err := v.Int64(); err == nil {
+		return n, nil
+	}
+	if f, err := v.Float64(); err == nil {
+		return int64(f), nil
+	}
+	return 0, fmt.Errorf("field %s is not a number", strings.Join(e, "."))
+}
+
+type negExpr struct{ x expr }
+
+func (e negExpr) eval(obj *jason.Object) (int64, error) {
+	x, err := e.x.eval(obj)
+	return -x, err
+}
+
+type binaryExpr struct {
+	op   byte
+	x, y expr
+}
+
+func (e binaryExpr) eval(obj *jason.Object) (int64, error) {
+	x, err := e.x.eval(obj)
+	if err != nil {
+		return 0, err
+	}
+	y, err := e.y.eval(obj)
+	if err != nil {
+		return 0, err
+	}
+	switch e.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	}
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	if e.op == '/' {
+		return x / y, nil
+	}
+	return x % y, nil
+}
+
+// predicate compares field of the array element with the literal.
+type predicate struct {
+	field []string
+	op    string
+	value interface{} // float64, string, bool or nil
+}
+
+// matchFilter returns true if object matches all predicates.
+func matchFilter(filter []predicate, obj *jason.Object) bool {
+	for _, p := range filter {
+		if !p.match(obj) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p predicate) match(obj *jason.Object) bool {
+	v, err := obj.GetValue(p.field...)
+	if err != nil {
+		return false
+	}
+
+	var cmp int
+	switch want := p.value.(type) {
+	case float64:
+		got, err := v.Float64()
+		if err != nil {
+			return false
+		}
+		cmp = compareFloats(got, want)
+	case string:
+		got, err := v.String()
+		if err != nil {
+			return false
+		}
+		cmp = strings.Compare(got, want)
+	case bool:
+		got, err := v.Boolean()
+		if err != nil || got != want {
+			return p.op == "!="
+		}
+		return p.op == "=="
+	case nil:
+		isNull := v.Null() == nil
+		return isNull == (p.op == "==")
+	}
+
+	switch p.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// exprParser is a recursive descent parser of index expressions.
+type exprParser struct {
+	s   string
+	pos int
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d of [%s]", fmt.Sprintf(format, args...), p.pos+1, p.s)
+}
+
+func (p *exprParser) eof() bool { return p.pos >= len(p.s) }
+
+func (p *exprParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *exprParser) skipSpace() {
+	for !p.eof() && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// expr parses sum: term {(+|-) term}.
+func (p *exprParser) expr() (expr, error) {
+	x, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op, x, y}
+	}
+}
+
+// term parses product: factor {(*|/|%) factor}.
+func (p *exprParser) term() (expr, error) {
+	x, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpace()
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op, x, y}
+	}
+}
+
+// factor parses number, field, negation or expression in parens.
+func (p *exprParser) factor() (expr, error) {
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case p.eof():
+		return nil, p.errorf("unexpected end of expression")
+	case c == '-':
+		p.pos++
+		x, err := p.factor()
+		return negExpr{x}, err
+	case c == '(':
+		p.pos++
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.peek() != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return x, nil
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for !p.eof() && p.peek() >= '0' && p.peek() <= '9' {
+			p.pos++
+		}
+		n, err := strconv.ParseInt(p.s[start:p.pos], 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", p.s[start:p.pos])
+		}
+		return numberExpr(n), nil
+	case isIdentStart(c):
+		return fieldExpr(p.field()), nil
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+// field parses dotted field name, like "NumGC" or "Stats.Count".
+func (p *exprParser) field() []string {
+	start := p.pos
+	for !p.eof() && (isIdentStart(p.peek()) || p.peek() >= '0' && p.peek() <= '9' || p.peek() == '.') {
+		p.pos++
+	}
+	return strings.Split(p.s[start:p.pos], ".")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// filter parses predicates, joined with &&.
+func (p *exprParser) filter() ([]predicate, error) {
+	var filter []predicate
+	for {
+		p.skipSpace()
+		if !isIdentStart(p.peek()) {
+			return nil, p.errorf("expected field name")
+		}
+		pred := predicate{field: p.field()}
+
+		p.skipSpace()
+		for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+			if strings.HasPrefix(p.s[p.pos:], op) {
+				pred.op = op
+				break
+			}
+		}
+		if pred.op == "" {
+			return nil, p.errorf("expected comparison operator (==, !=, <, <=, >, >=)")
+		}
+		p.pos += len(pred.op)
+
+		value, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := value.(float64); !ok && pred.op != "==" && pred.op != "!=" {
+			if _, ok := value.(string); !ok {
+				return nil, p.errorf("%s can't be compared with %s", pred.op, fmtLiteral(value))
+			}
+		}
+		pred.value = value
+		filter = append(filter, pred)
+
+		p.skipSpace()
+		if !strings.HasPrefix(p.s[p.pos:], "&&") {
+			return filter, nil
+		}
+		p.pos += 2
+	}
+}
+
+// literal parses number, quoted string, true, false or null.
+func (p *exprParser) literal() (interface{}, error) {
+	p.skipSpace()
+	c := p.peek()
+	switch {
+	case c == '"':
+		start := p.pos
+		p.pos++
+		for !p.eof() && p.peek() != '"' {
+			if p.peek() == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.eof() {
+			return nil, p.errorf("unterminated string")
+		}
+		p.pos++
+		s, err := strconv.Unquote(p.s[start:p.pos])
+		if err != nil {
+			return nil, p.errorf("invalid string %s", p.s[start:p.pos])
+		}
+		return s, nil
+	case c == '-' || c == '.' || c >= '0' && c <= '9':
+		start := p.pos
+		p.pos++
+		for !p.eof() && strings.IndexByte("0123456789.eE+-", p.peek()) >= 0 {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil || math.IsNaN(f) {
+			return nil, p.errorf("invalid number %s", p.s[start:p.pos])
+		}
+		return f, nil
+	}
+	for _, word := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(p.s[p.pos:], word) {
+			p.pos += len(word)
+			switch word {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+			return nil, nil
+		}
+	}
+	return nil, p.errorf("expected number, string, true, false or null")
+}
+
+func fmtLiteral(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
+
+// Validate checks var name syntax: modifier parameters, keys and
+// array indexes, or expression of computed var.
+func (v VarName) Validate() error {
+	mods, _ := v.split()
+	for _, mod := range mods {
+		if paramModifiers[mod] {
+			return fmt.Errorf("invalid var %q: no value for %s: modifier", v, mod)
+		}
+	}
+	if s, ok := v.param("scale"); ok {
+		if _, ok := v.Scale(); !ok {
+			return fmt.Errorf("invalid var %q: invalid scale %q", v, s)
+		}
+	}
+	if v.Computed() {
+		if _, err := v.computed(); err != nil {
+			return fmt.Errorf("invalid var %q: %v", v, err)
+		}
+		return nil
+	}
+	_, path := v.split()
+	slice := DottedFieldsToSliceEscaped(path)
+	if len(slice) == 0 {
+		return fmt.Errorf("invalid var %q: empty path", v)
+	}
+	for _, s := range slice {
+		seg, err := parseSegment(s)
+		if err != nil {
+			return fmt.Errorf("invalid var %q: %v", v, err)
+		}
+		if seg.Key == "" {
+			return fmt.Errorf("invalid var %q: empty key before %s", v, seg.Indexes[0])
+		}
+	}
+	return nil
+}
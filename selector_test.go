@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const selectorJSON = `{
+	"memstats": {
+		"NumGC": 258,
+		"PauseNs": [0, 1, 2, 3],
+		"BySize": [{"Size": 0, "Mallocs": 1, "Name": "tiny"}, {"Size": 1024, "Mallocs": 50, "Name": "a.b"}, {"Size": 2048, "Mallocs": 7, "Name": null}]
+	},
+	"matrix": [[1, 2], [3, 4]]
+}`
+
+func TestSelectorLookup(t *testing.T) {
+	expvar, err := ParseExpvar(strings.NewReader(selectorJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name VarName
+		want int64
+	}{
+		{"memstats.PauseNs[2]", 2},
+		{"memstats.PauseNs[-1]", 3},
+		{"memstats.PauseNs[ -4 ]", 0},
+		{"memstats.PauseNs[(NumGC+255)%256]", 1},
+		{"memstats.PauseNs[NumGC % 4 * 2 - 3]", 1},
+		{"memstats.BySize[?Size==1024].Mallocs", 50},
+		{"memstats.BySize[?Size>0 && Mallocs<10].Size", 2048},
+		{`memstats.BySize[?Name=="a.b"].Size`, 1024},
+		{"memstats.BySize[?Name==null].Mallocs", 7},
+		{"memstats.BySize[?Name!=null].Mallocs", 1},
+		{"matrix[1][-2]", 3},
+	}
+	for _, test := range tests {
+		if err := test.name.Validate(); err != nil {
+			t.Fatalf("Expecting %s to be valid, but got %v", test.name, err)
+		}
+		v, err := expvar.Lookup(test.name.ToSlice()...)
+		if err != nil {
+			t.Fatalf("Expecting %s to be found: %v", test.name, err)
+		}
+		if n, _ := v.Int64(); n != test.want {
+			t.Fatalf("Expecting %s to be %d, but got %d", test.name, test.want, n)
+		}
+	}
+
+	for _, name := range []VarName{
+		"memstats.PauseNs[4]",
+		"memstats.PauseNs[-5]",
+		"memstats.PauseNs[NumGC/0]",
+		"memstats.PauseNs[Missing]",
+		"memstats.BySize[?Size==1]",
+	} {
+		if _, err := expvar.Lookup(name.ToSlice()...); err == nil {
+			t.Fatalf("Expecting %s lookup to fail", name)
+		}
+	}
+}
+
+func TestSelectorErrors(t *testing.T) {
+	tests := []struct {
+		name VarName
+		err  string
+	}{
+		{"memstats.PauseNs[]", "empty index"},
+		{"memstats.PauseNs[1", "unclosed bracket"},
+		{"memstats.PauseNs[(NumGC+1]", "expected ) at position 9 of [(NumGC+1]"},
+		{"memstats.PauseNs[1+]", "unexpected end of expression at position 3 of [1+]"},
+		{"memstats.PauseNs[1 2]", `unexpected "2" at position 3 of [1 2]`},
+		{"memstats.BySize[?Size=1]", "expected comparison operator"},
+		{"memstats.BySize[?Size>true]", "> can't be compared with true"},
+		{"memstats.BySize[?Name==a]", "expected number, string, true, false or null"},
+		{"memstats.BySize[0]x", `unexpected "x" after index`},
+		{"memstats.[0]", "empty key before [0]"},
+	}
+	for _, test := range tests {
+		err := test.name.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("Expecting %s to fail with %q, but got %v", test.name, test.err, err)
+		}
+	}
+
+	if _, err := ParseVars("memstats.Alloc,memstats.PauseNs[-]"); err == nil {
+		t.Fatal("Expecting invalid selector to fail vars parsing")
+	}
+
+	_, err := ParseConfig([]byte(`{
+	"vars": ["memstats.Alloc",
+		"memstats.PauseNs[(NumGC+255)%256"]
+}`))
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 1 || errs[0].Line != 3 || errs[0].Field != "vars[1]" {
+		t.Fatalf("Expecting invalid selector to be reported at line 3, but got %v", err)
+	}
+}
+
+func TestSelectorCompatibility(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"memstats.Alloc", []string{"memstats", "Alloc"}},
+		{`counters.v1\.2.hits`, []string{"counters", "v1.2", "hits"}},
+		{`keys.a\[0\]`, []string{"keys", `a\[0\]`}},
+		{`memstats.BySize[?Name=="a.b"].Size`, []string{"memstats", `BySize[?Name=="a.b"]`, "Size"}},
+		{"stats.Items[Meta.Count-1].Value", []string{"stats", "Items[Meta.Count-1]", "Value"}},
+		{`http_requests_total{path="/a.b"}`, []string{`http_requests_total{path="/a.b"}`}},
+	}
+	for _, test := range tests {
+		if got := DottedFieldsToSliceEscaped(test.path); !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("Expecting %s to be split into %q, but got %q", test.path, test.want, got)
+		}
+	}
+
+	expvar, err := ParseExpvar(strings.NewReader(`{"keys": {"a[0]": 5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := expvar.Lookup(VarName(`keys.a\[0\]`).ToSlice()...); err != nil {
+		t.Fatalf("Expecting escaped brackets to be found: %v", err)
+	} else if n, _ := v.Int64(); n != 5 {
+		t.Fatalf("Expecting escaped key value to be 5, but got %d", n)
+	}
+
+	// expressions need fields of the whole object
+	extracted, err := ExtractExpvar(strings.NewReader(selectorJSON), []VarName{"memstats.PauseNs[(NumGC+255)%256]", "memstats.BySize[-1].Size"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := extracted.Lookup(VarName("memstats.PauseNs[(NumGC+255)%256]").ToSlice()...); err != nil {
+		t.Fatalf("Expecting expression to be resolved in extracted data: %v", err)
+	} else if n, _ := v.Int64(); n != 1 {
+		t.Fatalf("Expecting expression value to be 1, but got %d", n)
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"net/url"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/antonholmquist/jason"
 )
@@ -21,44 +24,225 @@ type Service struct {
 	URL     url.URL
 	Name    string
 	Cmdline string
+	Source  Source
+	Options FetchOptions
+
+	// fixedName is set when Name is configured explicitly
+	// and shouldn't be resolved from cmdline.
+	fixedName bool
+
+	// Discovered is set for services found by discovery providers,
+	// Found and Vanished are times service appeared and disappeared.
+	Discovered bool
+	Found      time.Time
+	Vanished   time.Time
+
+	// Expvar holds the last fetched expvar data.
+	Expvar *Expvar
 
 	stacks map[VarName]*Stack
+	rates  map[VarName]*Rate
+
+	// patterns holds wildcard vars with vars they were expanded to.
+	patterns map[VarName][]VarName
 
 	Err           error
 	Restarted     bool
 	UptimeCounter int64
+
+	// Status and Latency describe the last fetch attempt, and
+	// Response its HTTP response, if source is fetched by HTTP.
+	Status   FetchStatus
+	Latency  time.Duration
+	Response *ResponseInfo
+
+	// Failures is a number of failed polls in a row, and
+	// NextAttempt is a time of the next poll, if backing off.
+	Failures    int
+	NextAttempt time.Time
+
+	// Attempts is a number of fetch attempts made; polls skipped
+	// while backing off are not counted.
+	Attempts int
 }
 
 // NewService returns new Service object.
 func NewService(url url.URL, vars []VarName) *Service {
-	values := make(map[VarName]*Stack)
+	s := &Service{
+		Name:    defaultName(url), // we have only port on start, so use it as name until resolved
+		URL:     url,
+		Source:  NewSource(url, DefaultFetchOptions),
+		Options: DefaultFetchOptions,
+
+		stacks:   make(map[VarName]*Stack),
+		rates:    make(map[VarName]*Rate),
+		patterns: make(map[VarName][]VarName),
+	}
 	for _, name := range vars {
-		values[VarName(name)] = NewStack()
+		s.AddVar(name)
+	}
+	return s
+}
+
+// defaultName returns service name, used until it's resolved
+// from cmdline: host and port, file name or command.
+func defaultName(u url.URL) string {
+	if u.Scheme == FileScheme {
+		return filepath.Base(u.Path)
+	}
+	if IsStdin(u) {
+		return "stdin"
+	}
+	if socket, _, ok := UnixSocket(u); ok {
+		return filepath.Base(socket)
+	}
+	if command, ok := ExecCommand(u); ok {
+		return execName(command)
+	}
+	return u.Host
+}
+
+// AddVar starts monitoring of the var, if it's not monitored yet.
+// Wildcard vars are expanded into separate vars on every update.
+func (s *Service) AddVar(name VarName) {
+	if name.Wildcard() {
+		if _, ok := s.patterns[name]; !ok {
+			s.patterns[name] = nil
+		}
+		return
+	}
+	if _, ok := s.stacks[name]; ok {
+		return
+	}
+	s.stacks[name] = NewStack()
+	if name.Rate() {
+		s.rates[name] = &Rate{}
+	}
+}
+
+// RemoveVar stops monitoring of the var, dropping collected data.
+func (s *Service) RemoveVar(name VarName) {
+	for _, expanded := range s.patterns[name] {
+		s.RemoveVar(expanded)
 	}
+	delete(s.patterns, name)
+	delete(s.stacks, name)
+	delete(s.rates, name)
+}
 
-	return &Service{
-		Name: url.Host, // we have only port on start, so use it as name until resolved
-		URL:  url,
+// Expanded returns vars, wildcard var was expanded to, sorted by name.
+func (s *Service) Expanded(name VarName) []VarName {
+	return s.patterns[name]
+}
 
-		stacks: values,
+// expand expands wildcard vars into vars for keys found in expvar data.
+// Vars, once found, are kept even if keys disappear, so their history
+// is not lost, and at most maxSeries vars are monitored per wildcard.
+func (s *Service) expand(expvar *Expvar) {
+	for pattern, vars := range s.patterns {
+		if len(vars) >= *maxSeries {
+			continue
+		}
+		known := make(map[VarName]bool, len(vars))
+		for _, name := range vars {
+			known[name] = true
+		}
+		// data is walked only until the rest of series is found
+		for _, name := range expandVar(expvar, pattern, *maxSeries-len(vars), known) {
+			s.AddVar(name)
+			vars = append(vars, name)
+		}
+		sort.Slice(vars, func(i, j int) bool { return vars[i] < vars[j] })
+		s.patterns[pattern] = vars
 	}
 }
 
+// SetOptions sets fetch options for the service.
+func (s *Service) SetOptions(opts FetchOptions) {
+	s.Options = opts
+	s.Source = NewSource(s.URL, opts)
+}
+
 // Update updates Service info from Expvar variable.
+//
+// Failed fetches are retried according to options, and services
+// which are down are polled with exponential backoff.
 func (s *Service) Update(wg *sync.WaitGroup) {
 	defer wg.Done()
-	expvar, err := FetchExpvar(s.URL)
-	// check for restart
-	if s.Err != nil && err == nil {
+
+	now := time.Now()
+	if now.Before(s.NextAttempt) {
+		return
+	}
+
+	var expvar *Expvar
+	var err error
+	for attempt := 0; attempt <= s.Options.Retries; attempt++ {
+		start := time.Now()
+		expvar, err = s.Source.Fetch(s.vars())
+		s.Latency = time.Since(start)
+		if err == nil {
+			break
+		}
+	}
+	s.Response = nil
+	if r, ok := s.Source.(responseReporter); ok {
+		if info, ok := r.LastResponse(); ok {
+			s.Response = &info
+		}
+	}
+
+	s.Failures++
+	if err == nil {
+		s.Failures = 0
+	}
+	s.update(expvar, err, now)
+	s.NextAttempt = now.Add(backoff(s.Failures, *interval, s.Options.MaxBackoff))
+}
+
+// vars returns all vars, which data is needed for the service,
+// with computed vars replaced by their operands.
+func (s *Service) vars() []VarName {
+	var vars []VarName
+	for name := range s.stacks {
+		vars = append(vars, name)
+	}
+	for name := range s.patterns {
+		vars = append(vars, name)
+	}
+	return dataVars(vars)
+}
+
+// update updates Service info from expvar data, fetched at the given time.
+func (s *Service) update(expvar *Expvar, err error, now time.Time) {
+	s.Expvar = expvar
+	s.Attempts++
+
+	// check for restart; timeouts mean service is slow
+	// to respond, not that it was down
+	if s.Err != nil && err == nil && s.Status != StatusTimeout {
 		s.Restarted = true
 	}
 	s.Err = err
+	s.Status = fetchStatus(err, s.Latency, s.Options.SlowThreshold())
+
+	// Non-expvar sources (like Prometheus) may lack runtime vars,
+	// so they're optional for them.
+	runtime := true
+	if r, ok := s.Source.(runtimeReporter); ok {
+		runtime = r.HasRuntimeVars()
+	}
+	setErr := func(err error) {
+		if runtime && s.Err == nil {
+			s.Err = err
+		}
+	}
 
 	// if memstat.PauseTotalNs less than s.UptimeCounter
 	// then service was restarted
 	c, err := expvar.GetInt64(uptimeCounter...)
 	if err != nil {
-		s.Err = err
+		setErr(err)
 	} else {
 		if s.UptimeCounter > c {
 			s.Restarted = true
@@ -70,27 +254,73 @@ func (s *Service) Update(wg *sync.WaitGroup) {
 	if len(s.Cmdline) == 0 {
 		cmdline, err := expvar.GetStringArray("cmdline")
 		if err != nil {
-			s.Err = err
+			setErr(err)
 		} else {
 			s.Cmdline = strings.Join(cmdline, " ")
-			s.Name = BaseCommand(cmdline)
+			if !s.fixedName {
+				s.Name = BaseCommand(cmdline)
+			}
 		}
 	}
 
+	s.expand(expvar)
+
 	// For all vars, fetch desired value from Json and push to it's own stack.
 	for name, stack := range s.stacks {
-		value, err := expvar.GetValue(name.ToSlice()...)
-		if err != nil {
-			stack.Push(nil)
+		var v interface{}
+		if field, ok := name.fetchVar(); ok {
+			v = s.fetchValue(field)
+			if v == nil {
+				stack.PushAt(nil, now)
+				continue
+			}
+		} else if name.Computed() {
+			v = s.computedValue(expvar, name)
+			if v == nil {
+				stack.PushAt(nil, now)
+				continue
+			}
+		} else {
+			value, err := varValue(expvar, name)
+			if err != nil || value == nil {
+				stack.PushAt(nil, now)
+				continue
+			}
+			v = value
+		}
+		if rate, ok := s.rates[name]; ok {
+			stack.PushAt(rate.Update(v, now), now)
 			continue
 		}
-		v := guessValue(value)
 		if v != nil {
-			stack.Push(v)
+			stack.PushAt(v, now)
 		}
 	}
 }
 
+// Reset clears all collected data, preserving service URL and vars.
+func (s *Service) Reset() {
+	if !s.fixedName {
+		s.Name = defaultName(s.URL)
+	}
+	s.Cmdline = ""
+	s.Expvar = nil
+	s.Err = nil
+	s.Restarted = false
+	s.UptimeCounter = 0
+	s.Status = StatusOK
+	s.Latency = 0
+	s.Response = nil
+	s.Failures = 0
+	s.NextAttempt = time.Time{}
+	for name := range s.stacks {
+		s.stacks[name] = NewStack()
+	}
+	for name := range s.rates {
+		s.rates[name] = &Rate{}
+	}
+}
+
 // guessValue attemtps to bruteforce all supported types.
 func guessValue(value *jason.Value) interface{} {
 	if v, err := value.Int64(); err == nil {
@@ -126,7 +356,8 @@ func guessValue(value *jason.Value) interface{} {
 //
 // It also formats value, if kind is specified.
 func (s Service) Value(name VarName) string {
-	if s.Err != nil {
+	// fetch pseudo-vars are meaningful for failed fetches too
+	if _, ok := name.fetchVar(); s.Err != nil && !ok {
 		return "N/A"
 	}
 	val, ok := s.stacks[name]
@@ -139,7 +370,7 @@ func (s Service) Value(name VarName) string {
 		return "N/A"
 	}
 
-	return Format(v, name.Kind())
+	return name.Format(v)
 }
 
 // Values returns slice of ints with recent
@@ -165,5 +396,5 @@ func (s Service) Max(name VarName) interface{} {
 		return nil
 	}
 
-	return Format(v, name.Kind())
+	return name.Format(v)
 }
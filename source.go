@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/antonholmquist/jason"
+)
+
+// Source represents a source of expvar data for the service.
+type Source interface {
+	// Fetch fetches fresh data. Vars lists all vars monitored
+	// for the service, so source may extract only those; nil vars
+	// means all available data (used by vars browser).
+	Fetch(vars []VarName) (*Expvar, error)
+}
+
+// ExpvarSource fetches expvar JSON by HTTP.
+//
+// If paths are set, only values of the monitored vars are extracted
+// from JSON, as documents may be large and most of the data is not
+// needed. Otherwise the whole document is parsed.
+type ExpvarSource struct {
+	URL    url.URL
+	Client *http.Client
+
+	paths *varPaths
+	*responseRecorder
+}
+
+// Fetch implements Source.
+func (s ExpvarSource) Fetch(vars []VarName) (*Expvar, error) {
+	var paths pathTrie
+	if vars != nil && s.paths != nil {
+		paths = s.paths.get(vars)
+	}
+	return fetchPaths(s.Client, s.URL, paths, s.responseRecorder)
+}
+
+// untracked returns copy of the source, which doesn't record responses.
+// It's used for fetches out of regular polling (vars browser, profiles),
+// so they don't overwrite info of the last poll, shown by _fetch vars.
+//
+// Only HTTP sources can be fetched out of polling, so false is returned
+// for others: extra fetch would consume snapshot of stdin stream or
+// replay, or run exec command once more.
+func untracked(src Source) (Source, bool) {
+	switch s := src.(type) {
+	case ExpvarSource:
+		s.responseRecorder = nil
+		return s, true
+	case PrometheusSource:
+		s.responseRecorder = nil
+		return s, true
+	}
+	return nil, false
+}
+
+// runtimeReporter is implemented by sources, which report whether
+// their data has Go runtime vars (memstats and cmdline). Missing
+// runtime vars are errors for sources, which don't implement it.
+type runtimeReporter interface {
+	HasRuntimeVars() bool
+}
+
+// NewSource returns source for the given URL, based on its scheme.
+//
+// "prom+http://host:port/metrics" URLs are fetched as Prometheus
+// metrics, "exec:command" URLs run the command, "file:///path" and
+// "-" (stdin) are read locally, everything else is expected to be
+// expvar JSON by HTTP.
+func NewSource(u url.URL, opts FetchOptions) Source {
+	if command, ok := ExecCommand(u); ok {
+		return ExecSource{Command: command, Timeout: opts.Timeout}
+	}
+	if u.Scheme == FileScheme {
+		return FileSource{Path: u.Path}
+	}
+	if IsStdin(u) {
+		return StdinSource()
+	}
+
+	client, err := NewHTTPClient(opts, u)
+	if err != nil {
+		return errorSource{err}
+	}
+	if strings.HasPrefix(u.Scheme, PrometheusSchemePrefix) {
+		u.Scheme = strings.TrimPrefix(u.Scheme, PrometheusSchemePrefix)
+		return PrometheusSource{URL: u, Client: client, responseRecorder: &responseRecorder{}}
+	}
+	src := ExpvarSource{URL: u, Client: client, responseRecorder: &responseRecorder{}}
+	if !opts.Full {
+		src.paths = &varPaths{}
+	}
+	return src
+}
+
+// errorSource is a Source for misconfigured services,
+// always returning configuration error.
+type errorSource struct {
+	err error
+}
+
+// Fetch implements Source.
+func (s errorSource) Fetch([]VarName) (*Expvar, error) {
+	return &Expvar{&jason.Object{}}, s.err
+}
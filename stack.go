@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 // DefaultSize specifies maximum number of items in stack.
 //
 // Values should be enough for sparklines on high-res terminals
@@ -9,6 +11,7 @@ const DefaultSize = 1200
 // Stack is a limited FIFO for holding sparkline values.
 type Stack struct {
 	Values []VarValue
+	Times  []time.Time // times values were fetched at
 	Len    int
 	Max    VarValue
 }
@@ -22,15 +25,23 @@ func NewStack() *Stack {
 func NewStackWithSize(size int) *Stack {
 	return &Stack{
 		Values: make([]VarValue, size),
+		Times:  make([]time.Time, size),
 		Len:    size,
 	}
 }
 
 // Push inserts data to stack, preserving constant length.
 func (s *Stack) Push(val VarValue) {
+	s.PushAt(val, time.Now())
+}
+
+// PushAt inserts data, fetched at the given time.
+func (s *Stack) PushAt(val VarValue, t time.Time) {
 	s.Values = append(s.Values, val)
+	s.Times = append(s.Times, t)
 	if len(s.Values) > s.Len {
 		s.Values = s.Values[1:]
+		s.Times = s.Times[1:]
 	}
 
 	if s.Max == nil {
@@ -103,3 +114,19 @@ func (s *Stack) IntValues() []int {
 	}
 	return ret
 }
+
+// FloatValues returns all numeric values with their times,
+// skipping empty and non-numeric ones.
+func (s *Stack) FloatValues() ([]float64, []time.Time) {
+	var values []float64
+	var times []time.Time
+	for i, v := range s.Values {
+		f, ok := toFloat64(v)
+		if !ok || s.Times[i].IsZero() {
+			continue
+		}
+		values = append(values, f)
+		times = append(times, s.Times[i])
+	}
+	return values, times
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/antonholmquist/jason"
+)
+
+// pathTrie is a tree of var paths to extract from expvar JSON.
+// Nil subtree means that the whole value is extracted.
+type pathTrie map[string]pathTrie
+
+// add adds path to the tree.
+func (t pathTrie) add(path []string) {
+	for i, key := range path {
+		sub, ok := t[key]
+		if ok && sub == nil {
+			// the whole value is already extracted
+			return
+		}
+		if i == len(path)-1 {
+			t[key] = nil
+			return
+		}
+		if !ok {
+			sub = make(pathTrie)
+			t[key] = sub
+		}
+		t = sub
+	}
+}
+
+// newPathTrie returns tree of paths for the vars, along with
+// cmdline and uptime counter, needed for every service. Nil tree
+// means that the whole document is needed.
+func newPathTrie(vars []VarName) pathTrie {
+	t := pathTrie{"cmdline": nil}
+	t.add(uptimeCounter)
+	for _, name := range vars {
+		if _, ok := name.fetchVar(); ok {
+			continue
+		}
+		path := staticPath(name.ToSlice())
+		if len(path) == 0 {
+			return nil
+		}
+		t.add(path)
+	}
+	return t
+}
+
+// varPaths caches path tree for the set of vars, so it's not
+// rebuilt on every poll.
+type varPaths struct {
+	mu   sync.Mutex
+	vars []VarName
+	trie pathTrie
+}
+
+// get returns path tree for the vars.
+func (p *varPaths) get(vars []VarName) pathTrie {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !sameVars(p.vars, vars) {
+		p.vars = append(p.vars[:0], vars...)
+		sort.Slice(p.vars, func(i, j int) bool { return p.vars[i] < p.vars[j] })
+		p.trie = newPathTrie(vars)
+	}
+	return p.trie
+}
+
+// sameVars returns true if vars contain the same names as sorted ones.
+func sameVars(sorted, vars []VarName) bool {
+	if len(sorted) != len(vars) || sorted == nil {
+		return false
+	}
+	for _, name := range vars {
+		i := sort.Search(len(sorted), func(i int) bool { return sorted[i] >= name })
+		if i == len(sorted) || sorted[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonScanner streams JSON document, copying only values
+// at the requested paths into the pruned document.
+type jsonScanner struct {
+	r      *bufio.Reader
+	out    []byte // pruned document
+	key    []byte // decoded current key
+	rawKey []byte // current key, as in document
+}
+
+// scanners are reused between polls.
+var scanners = sync.Pool{
+	New: func() interface{} {
+		return &jsonScanner{r: bufio.NewReaderSize(nil, 32*1024)}
+	},
+}
+
+// ExtractExpvar streams expvar JSON from r, extracting only values
+// for the given vars, along with cmdline and uptime counter. It's
+// much cheaper than ParseExpvar for large documents.
+func ExtractExpvar(r io.Reader, vars []VarName) (*Expvar, error) {
+	return extractExpvar(r, newPathTrie(vars))
+}
+
+func extractExpvar(r io.Reader, paths pathTrie) (*Expvar, error) {
+	if paths == nil {
+		return ParseExpvar(r)
+	}
+
+	s := scanners.Get().(*jsonScanner)
+	defer scanners.Put(s)
+	s.r.Reset(r)
+	defer s.r.Reset(nil)
+	s.out = s.out[:0]
+
+	c, err := s.next()
+	if err != nil {
+		return &Expvar{&jason.Object{}}, err
+	}
+	if c != '{' {
+		return &Expvar{&jason.Object{}}, fmt.Errorf("invalid JSON: expected object, got %q", c)
+	}
+	s.out = append(s.out, '{')
+	if err := s.object(paths); err != nil {
+		return &Expvar{&jason.Object{}}, err
+	}
+
+	// jason copies all data, so buffer can be reused
+	object, err := jason.NewObjectFromBytes(s.out)
+	if err != nil {
+		return &Expvar{&jason.Object{}}, err
+	}
+	return &Expvar{object}, nil
+}
+
+// next returns the next non-whitespace byte.
+func (s *jsonScanner) next() (byte, error) {
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return c, nil
+	}
+}
+
+// object scans object members, after opening brace, copying
+// ones in paths tree to output.
+func (s *jsonScanner) object(paths pathTrie) error {
+	first := true
+	for {
+		c, err := s.next()
+		if err != nil {
+			return err
+		}
+		switch c {
+		case '}':
+			s.out = append(s.out, '}')
+			return nil
+		case ',':
+			continue
+		case '"':
+		default:
+			return fmt.Errorf("invalid JSON: unexpected %q in object", c)
+		}
+
+		if err := s.readKey(); err != nil {
+			return err
+		}
+		if c, err := s.next(); err != nil {
+			return err
+		} else if c != ':' {
+			return fmt.Errorf("invalid JSON: expected colon after key, got %q", c)
+		}
+
+		sub, ok := paths[string(s.key)]
+		if !ok {
+			if err := s.value(false); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if sub != nil {
+			// only objects can have nested paths
+			c, err := s.next()
+			if err != nil {
+				return err
+			}
+			if c != '{' {
+				s.r.UnreadByte()
+				if err := s.value(false); err != nil {
+					return err
+				}
+				continue
+			}
+			s.writeKey(&first)
+			s.out = append(s.out, '{')
+			if err := s.object(sub); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s.writeKey(&first)
+		if err := s.value(true); err != nil {
+			return err
+		}
+	}
+}
+
+// writeKey writes current key to output.
+func (s *jsonScanner) writeKey(first *bool) {
+	if !*first {
+		s.out = append(s.out, ',')
+	}
+	*first = false
+	s.out = append(s.out, '"')
+	s.out = append(s.out, s.rawKey...)
+	s.out = append(s.out, '"', ':')
+}
+
+// readKey reads object key, after opening quote.
+func (s *jsonScanner) readKey() error {
+	s.key, s.rawKey = s.key[:0], s.rawKey[:0]
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if c == '"' {
+			return nil
+		}
+		s.rawKey = append(s.rawKey, c)
+		if c != '\\' {
+			s.key = append(s.key, c)
+			continue
+		}
+
+		c, err = s.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		s.rawKey = append(s.rawKey, c)
+		switch c {
+		case 'b':
+			s.key = append(s.key, '\b')
+		case 'f':
+			s.key = append(s.key, '\f')
+		case 'n':
+			s.key = append(s.key, '\n')
+		case 'r':
+			s.key = append(s.key, '\r')
+		case 't':
+			s.key = append(s.key, '\t')
+		case 'u':
+			r, err := s.readRune()
+			if err != nil {
+				return err
+			}
+			s.key = appendRune(s.key, r)
+		default:
+			s.key = append(s.key, c)
+		}
+	}
+}
+
+// readRune reads hex digits of \u escape, handling surrogate pairs.
+func (s *jsonScanner) readRune() (rune, error) {
+	r, err := s.readHex()
+	if err != nil || !utf16.IsSurrogate(r) {
+		return r, err
+	}
+	// low surrogate should follow as \uXXXX
+	for _, want := range []byte{'\\', 'u'} {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		s.rawKey = append(s.rawKey, c)
+		if c != want {
+			return utf8.RuneError, nil
+		}
+	}
+	r2, err := s.readHex()
+	return utf16.DecodeRune(r, r2), err
+}
+
+func (s *jsonScanner) readHex() (rune, error) {
+	var r rune
+	for i := 0; i < 4; i++ {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		s.rawKey = append(s.rawKey, c)
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, errors.New("invalid JSON: invalid unicode escape")
+		}
+		r = r<<4 | rune(c)
+	}
+	return r, nil
+}
+
+// value scans the next value, copying it to output if emit is set.
+func (s *jsonScanner) value(emit bool) error {
+	c, err := s.next()
+	if err != nil {
+		return err
+	}
+	if emit {
+		s.out = append(s.out, c)
+	}
+
+	switch c {
+	case '{', '[':
+		return s.composite(emit)
+	case '"':
+		return s.str(emit)
+	}
+
+	// number, true, false or null, till delimiter
+	for {
+		c, err := s.r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch c {
+		case ',', '}', ']', ' ', '\t', '\n', '\r':
+			return s.r.UnreadByte()
+		}
+		if emit {
+			s.out = append(s.out, c)
+		}
+	}
+}
+
+// str scans string, after opening quote.
+func (s *jsonScanner) str(emit bool) error {
+	escaped := false
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if emit {
+			s.out = append(s.out, c)
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			return nil
+		}
+	}
+}
+
+// composite scans object or array, after opening bracket.
+func (s *jsonScanner) composite(emit bool) error {
+	depth := 1
+	for depth > 0 {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if emit {
+			s.out = append(s.out, c)
+		}
+		switch c {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		case '"':
+			if err := s.str(emit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendRune appends UTF-8 encoding of the rune.
+func appendRune(b []byte, r rune) []byte {
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	return append(b, buf[:n]...)
+}
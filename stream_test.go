@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExtractExpvar(t *testing.T) {
+	doc := `{
+		"cmdline": ["/bin/app", "-v"],
+		"skipped": {"a": [1, {"b": "}]\"{["}], "c": null},
+		"memstats": {"Alloc": 2048, "PauseTotalNs": 100, "PauseNs": [1, 2, 3], "BySize": [{"Size": 8}]},
+		"dotted.name": 1.5,
+		"escaped": "value",
+		"scalar": 42,
+		"nested": {"deep": {"value": true, "other": false}}
+	}`
+	vars := []VarName{"mem:memstats.Alloc", "memstats.PauseNs", `dotted\.name`, "escaped", "scalar.field", "nested.deep.value", "missing"}
+
+	expvar, err := ExtractExpvar(strings.NewReader(doc), vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, _ := ParseExpvar(strings.NewReader(doc))
+	for _, name := range append(vars, "cmdline", "memstats.PauseTotalNs") {
+		want, werr := full.GetValue(name.ToSlice()...)
+		got, gerr := expvar.GetValue(name.ToSlice()...)
+		if (werr == nil) != (gerr == nil) {
+			t.Fatalf("Expecting %s to be extracted the same way as parsed: %v, %v", name, werr, gerr)
+		}
+		if werr != nil {
+			continue
+		}
+		wb, _ := want.Marshal()
+		gb, _ := got.Marshal()
+		if !bytes.Equal(wb, gb) {
+			t.Fatalf("Expecting %s to be %s, but got %s", name, wb, gb)
+		}
+	}
+
+	// only requested data is extracted
+	for _, path := range [][]string{{"skipped"}, {"memstats", "BySize"}, {"nested", "deep", "other"}} {
+		if _, err := expvar.GetValue(path...); err == nil {
+			t.Fatalf("Expecting %v not to be extracted", path)
+		}
+	}
+
+	for _, doc := range []string{``, `[]`, `{"cmdline": ["app"`, `{"a" 1}`} {
+		if _, err := ExtractExpvar(strings.NewReader(doc), vars); err == nil {
+			t.Fatalf("Expecting invalid document %q to fail", doc)
+		}
+	}
+}
+
+func TestVarPaths(t *testing.T) {
+	var p varPaths
+	first := p.get([]VarName{"a.b", "c"})
+	if _, ok := first["a"]["b"]; !ok {
+		t.Fatalf("Expecting a.b path in tree, but got %v", first)
+	}
+	// the same vars in different order reuse the tree
+	if second := p.get([]VarName{"c", "a.b"}); fmt.Sprint(second) != fmt.Sprint(first) {
+		t.Fatalf("Expecting tree to be reused")
+	}
+	if third := p.get([]VarName{"c", "a"}); third["a"] != nil {
+		t.Fatalf("Expecting the whole value of a to be extracted, but got %v", third)
+	}
+}
+
+// benchDoc returns large expvar document, with memstats and
+// the map of n entries.
+func benchDoc(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"cmdline": ["/usr/bin/app", "-config", "app.json"], "memstats": {"Alloc": 123456, "TotalAlloc": 9876543, "HeapAlloc": 123456, "NumGC": 100, "PauseTotalNs": 55555, "PauseNs": [`)
+	for i := 0; i < 256; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%d", 1000+i)
+	}
+	buf.WriteString(`], "BySize": [`)
+	for i := 0; i < 61; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"Size": %d, "Mallocs": %d, "Frees": %d}`, i*16, i*1000, i*900)
+	}
+	buf.WriteString(`]}, "requests": {`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `"/api/v1/items/%d": {"count": %d, "latency": "%dms"}`, i, i*7, i%100)
+	}
+	buf.WriteString(`}, "Goroutines": 42}`)
+	return buf.Bytes()
+}
+
+var benchVars = []VarName{"mem:memstats.Alloc", "mem:memstats.HeapAlloc", "duration:memstats.PauseNs", "memstats.NumGC", "Goroutines", "rate:mem:memstats.TotalAlloc"}
+
+func BenchmarkParseExpvar(b *testing.B) {
+	doc := benchDoc(20000)
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseExpvar(bytes.NewReader(doc)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExtractExpvar(b *testing.B) {
+	doc := benchDoc(20000)
+	b.SetBytes(int64(len(doc)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ExtractExpvar(bytes.NewReader(doc), benchVars); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkPoll measures single poll of 500 services, each
+// serving document on its own port.
+func benchmarkPoll(b *testing.B, full bool) {
+	doc := benchDoc(2000)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+
+	var services []*Service
+	for i := 0; i < 500; i++ {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		u, _ := url.Parse(server.URL + "/debug/vars")
+		service := NewService(*u, benchVars)
+		service.SetOptions(FetchOptions{Timeout: 0, Full: full})
+		services = append(services, service)
+	}
+
+	poll := func() {
+		var wg sync.WaitGroup
+		for _, service := range services {
+			wg.Add(1)
+			go service.Update(&wg)
+		}
+		wg.Wait()
+	}
+	// establish connections
+	poll()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		poll()
+	}
+	b.StopTimer()
+	for _, service := range services {
+		if service.Err != nil {
+			b.Fatal(service.Err)
+		}
+	}
+}
+
+func BenchmarkPoll500Full(b *testing.B)    { benchmarkPoll(b, true) }
+func BenchmarkPoll500Extract(b *testing.B) { benchmarkPoll(b, false) }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// TLSOptions configures TLS connections to services.
+type TLSOptions struct {
+	// CAFile is a PEM bundle with CA certificates to verify services with.
+	CAFile string
+	// CertFile and KeyFile are PEM files with client certificate and key.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides name used for SNI and certificate verification.
+	ServerName string
+	// Insecure disables verification of service certificates.
+	Insecure bool
+}
+
+// IsZero returns true if no TLS options are set.
+func (o TLSOptions) IsZero() bool {
+	return o == TLSOptions{}
+}
+
+// Merge returns options with non-empty values of other options
+// overriding the current ones.
+func (o TLSOptions) Merge(other TLSOptions) TLSOptions {
+	if other.CAFile != "" {
+		o.CAFile = other.CAFile
+	}
+	// client certificate and key go in pair
+	if other.CertFile != "" || other.KeyFile != "" {
+		o.CertFile, o.KeyFile = other.CertFile, other.KeyFile
+	}
+	if other.ServerName != "" {
+		o.ServerName = other.ServerName
+	}
+	if other.Insecure {
+		o.Insecure = true
+	}
+	return o
+}
+
+// Config returns TLS config for the options, loading certificates.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.Insecure,
+	}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("TLS CA: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS CA: no certificates found in %s", o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("TLS client certificate: both cert and key files should be specified")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("TLS client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// TLSError is an error of TLS connection to the service,
+// with short human-readable reason.
+type TLSError struct {
+	Reason string
+	Err    error
+}
+
+func (e *TLSError) Error() string {
+	return fmt.Sprintf("TLS: %s: %v", e.Reason, e.Err)
+}
+
+// Unwrap returns underlying error.
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+// wrapTLSError wraps TLS related errors into TLSError,
+// other errors are returned as is.
+func wrapTLSError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var (
+		authErr     x509.UnknownAuthorityError
+		hostErr     x509.HostnameError
+		invalidErr  x509.CertificateInvalidError
+		recordErr   tls.RecordHeaderError
+		verifyErr   *tls.CertificateVerificationError
+		reason      string
+		msg         = err.Error()
+		remoteAlert = strings.Contains(msg, "remote error: tls: ")
+	)
+	switch {
+	case errors.As(err, &authErr):
+		reason = "unknown certificate authority"
+	case errors.As(err, &hostErr):
+		reason = "certificate name mismatch"
+	case errors.As(err, &invalidErr):
+		reason = "invalid certificate"
+		if invalidErr.Reason == x509.Expired {
+			reason = "certificate expired"
+		}
+	case errors.As(err, &verifyErr):
+		reason = "certificate verification failed"
+	case errors.As(err, &recordErr),
+		strings.Contains(msg, "server gave HTTP response to HTTPS client"):
+		reason = "service doesn't speak TLS"
+	case remoteAlert && (strings.Contains(msg, "certificate required") || strings.Contains(msg, "bad certificate")):
+		reason = "client certificate rejected"
+	case remoteAlert:
+		reason = "handshake failed"
+	default:
+		return err
+	}
+	return &TLSError{Reason: reason, Err: err}
+}
+
+// tlsReason returns short reason of TLS error. Replayed errors
+// are available only as text, so reason is extracted from it.
+func tlsReason(err error) (string, bool) {
+	var tlsErr *TLSError
+	if errors.As(err, &tlsErr) {
+		return tlsErr.Reason, true
+	}
+
+	msg := err.Error()
+	if i := strings.Index(msg, "TLS: "); i >= 0 {
+		reason := msg[i+len("TLS: "):]
+		if j := strings.Index(reason, ": "); j >= 0 {
+			reason = reason[:j]
+		}
+		return reason, true
+	}
+	return "", false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTLSTestServer(t *testing.T, clientAuth tls.ClientAuthType) (*httptest.Server, url.URL, string) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"goroutines": 10}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: clientAuth}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL + "/debug/vars")
+	return server, *u, ca
+}
+
+func TestTLSFetch(t *testing.T) {
+	server, u, ca := newTLSTestServer(t, tls.NoClientCert)
+	defer server.Close()
+
+	var tests = []struct {
+		opts   TLSOptions
+		reason string
+	}{
+		{TLSOptions{}, "unknown certificate authority"},
+		{TLSOptions{CAFile: ca}, ""},
+		{TLSOptions{CAFile: ca, ServerName: "other.example"}, "certificate name mismatch"},
+		{TLSOptions{Insecure: true}, ""},
+	}
+	for _, test := range tests {
+		client, err := NewHTTPClient(FetchOptions{TLS: test.opts}, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fetchExpvar(client, u)
+		if test.reason == "" {
+			if err != nil {
+				t.Fatalf("Expecting fetch with %+v to succeed, but got %v", test.opts, err)
+			}
+			continue
+		}
+		if s := fetchStatus(err, 0, 0); s != StatusTLS {
+			t.Fatalf("Expecting status to be tls, but got %v (%v)", s, err)
+		}
+		if reason, _ := tlsReason(err); reason != test.reason {
+			t.Fatalf("Expecting reason %q for %+v, but got %q (%v)", test.reason, test.opts, reason, err)
+		}
+	}
+}
+
+func TestTLSClientCertRequired(t *testing.T) {
+	server, u, ca := newTLSTestServer(t, tls.RequireAnyClientCert)
+	defer server.Close()
+
+	client, _ := NewHTTPClient(FetchOptions{TLS: TLSOptions{CAFile: ca}}, u)
+	_, err := fetchExpvar(client, u)
+	if reason, _ := tlsReason(err); reason != "client certificate rejected" {
+		t.Fatalf("Expecting client certificate to be rejected, but got %q (%v)", reason, err)
+	}
+
+	// replayed errors keep the reason
+	replayed := errors.New(err.Error())
+	if s := fetchStatus(replayed, 0, 0); s != StatusTLS {
+		t.Fatalf("Expecting replayed status to be tls, but got %v", s)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	var tests = []struct {
+		opts TLSOptions
+		err  string
+	}{
+		{TLSOptions{CAFile: "nonexistent.pem"}, "TLS CA"},
+		{TLSOptions{CertFile: "client.pem"}, "both cert and key"},
+		{TLSOptions{CertFile: "client.pem", KeyFile: "client-key.pem"}, "TLS client certificate"},
+	}
+	for _, test := range tests {
+		_, err := test.opts.Config()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("Expecting %+v to fail with %q, but got %v", test.opts, test.err, err)
+		}
+	}
+
+	// misconfigured services report error on fetch
+	src := NewSource(NewURL("https://localhost:1234"), FetchOptions{TLS: tests[0].opts})
+	if _, err := src.Fetch(nil); err == nil || !strings.Contains(err.Error(), "TLS CA") {
+		t.Fatalf("Expecting source to fail with config error, but got %v", err)
+	}
+}
@@ -6,3 +6,65 @@ type UI interface {
 	Close()
 	Update(UIData)
 }
+
+// MultiUI is an UI implementation, which passes data to several UIs at once.
+type MultiUI []UI
+
+// Init implements UI.
+func (m MultiUI) Init(data UIData) error {
+	for _, ui := range m {
+		if err := ui.Init(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements UI.
+func (m MultiUI) Close() {
+	for _, ui := range m {
+		ui.Close()
+	}
+}
+
+// Update implements UI.
+func (m MultiUI) Update(data UIData) {
+	for _, ui := range m {
+		ui.Update(data)
+	}
+}
+
+// KeyHandler is an optional interface for interactive UIs, handling
+// keyboard events. HandleKey may modify data (list of vars, for example)
+// and returns true if UI should be redrawn.
+type KeyHandler interface {
+	HandleKey(key string, data *UIData) bool
+}
+
+// HandleKey implements KeyHandler.
+func (m MultiUI) HandleKey(key string, data *UIData) bool {
+	var redraw bool
+	for _, ui := range m {
+		if h, ok := ui.(KeyHandler); ok && h.HandleKey(key, data) {
+			redraw = true
+		}
+	}
+	return redraw
+}
+
+// Overlayer is an optional interface for interactive UIs, showing
+// overlays (vars browser or zoom view) on top of the main view.
+// Keys go to the UI first while overlay is shown.
+type Overlayer interface {
+	Overlay() bool
+}
+
+// Overlay implements Overlayer.
+func (m MultiUI) Overlay() bool {
+	for _, ui := range m {
+		if o, ok := ui.(Overlayer); ok && o.Overlay() {
+			return true
+		}
+	}
+	return false
+}
@@ -27,10 +27,17 @@ func (*DummyUI) Update(data UIData) {
 			continue
 		}
 
-		for _, name := range data.Vars {
-			fmt.Printf("%s: %v, ", name.Short(), service.Value(name))
+		labels := Labels(data.Vars)
+		for i, name := range data.Vars {
+			fmt.Printf("%s: %v, ", labels[i], service.Value(name))
 		}
 
 		fmt.Printf("\n")
 	}
+
+	if data.Alerts != nil {
+		for _, alert := range data.Alerts.Active(data.Services) {
+			fmt.Printf("ALERT: %s\n", alert)
+		}
+	}
 }
@@ -15,6 +15,18 @@ type TermUI struct {
 	Lists      []*termui.List
 	Sparkline1 *termui.Sparklines
 	Sparkline2 *termui.Sparklines
+	Alerts     *termui.List
+
+	// Selected is an index of service, selected for vars browser,
+	// and SelectedVar is an index of var, selected for zoom view.
+	Selected    int
+	SelectedVar int
+	Browser     *Browser
+	Zoom        *Zoom
+
+	// vars and number of services widgets were created for
+	vars     []VarName
+	services int
 }
 
 // Init creates widgets, sets sizes and labels.
@@ -24,6 +36,15 @@ func (t *TermUI) Init(data UIData) error {
 		return err
 	}
 
+	t.build(data)
+	return nil
+}
+
+// build creates widgets for the given vars and services.
+func (t *TermUI) build(data UIData) {
+	t.vars = append([]VarName{}, data.Vars...)
+	t.services = len(data.Services)
+
 	t.Title = func() *termui.Paragraph {
 		p := termui.NewParagraph("")
 		p.Height = 3
@@ -52,12 +73,13 @@ func (t *TermUI) Init(data UIData) error {
 		return list
 	}()
 
+	labels := Labels(data.Vars)
 	t.Lists = make([]*termui.List, len(data.Vars))
 	for i, name := range data.Vars {
 		list := termui.NewList()
 		list.ItemFgColor = colorByKind(name.Kind())
 		list.Border = true
-		list.BorderLabel = name.Short()
+		list.BorderLabel = labels[i]
 		list.BorderLabelFg = termui.ColorGreen
 		if i < 2 {
 			list.BorderLabelFg = termui.ColorGreen | termui.AttrBold
@@ -79,23 +101,49 @@ func (t *TermUI) Init(data UIData) error {
 		s := termui.NewSparklines(sparklines...)
 		s.Height = 2*len(data.Services) + 2
 		s.Border = true
-		s.BorderLabel = fmt.Sprintf("Monitoring %s", name.Long())
+		s.BorderLabel = fmt.Sprintf("Monitoring %s", name.Title())
 		return s
 	}
+	if data.Alerts != nil {
+		t.Alerts = newAlertsList()
+	}
+
 	t.Sparkline1 = makeSparkline(data.Vars[0])
 	if len(data.Vars) > 1 {
 		t.Sparkline2 = makeSparkline(data.Vars[1])
 	}
 
 	t.Relayout()
-
-	return nil
 }
 
 // Update updates UI widgets from UIData.
 func (t *TermUI) Update(data UIData) {
-	t.Title.Text = fmt.Sprintf("monitoring %d services every %v, press q to quit", len(data.Services), *interval)
-	t.Status.Text = fmt.Sprintf("Last update: %v", data.LastTimestamp.Format(time.Stamp))
+	// vars may be added or removed in browser,
+	// and services may be added or removed by discovery
+	if !equalVars(t.vars, data.Vars) || t.services != len(data.Services) {
+		termui.Clear()
+		t.build(data)
+		if t.Selected >= len(data.Services) {
+			t.Selected = 0
+		}
+		if t.SelectedVar >= len(data.Vars) {
+			t.SelectedVar = 0
+		}
+	}
+
+	t.Title.Text = fmt.Sprintf("monitoring %d services every %v, press q to quit, b to browse vars, z to zoom, p to profile", len(data.Services), *interval)
+	t.Status.Text = data.StatusText()
+
+	if t.Browser != nil {
+		t.Browser.Update(data, t.Title.Height)
+		termui.Render(t.Title, t.Status, t.Browser.List)
+		return
+	}
+	if t.Zoom != nil {
+		t.Zoom.Update(data.Services, data.Vars[t.SelectedVar], t.Title.Height)
+		termui.Render(append([]termui.Bufferer{t.Title, t.Status}, t.Zoom.Buffers()...)...)
+		return
+	}
 
 	// List with service names
 	var services []string
@@ -106,12 +154,32 @@ func (t *TermUI) Update(data UIData) {
 
 	// Lists with values
 	for i, name := range data.Vars {
+		t.Lists[i].BorderFg = termui.ColorWhite
+		if i == t.SelectedVar {
+			t.Lists[i].BorderFg = termui.ColorCyan | termui.AttrBold
+		}
+
 		var lines []string
 		for _, service := range data.Services {
-			lines = append(lines, service.Value(name))
+			value := service.Value(name)
+			if data.Alerts != nil && data.Alerts.Firing(service, name) {
+				value = alertText(value)
+			}
+			lines = append(lines, value)
 		}
 		t.Lists[i].Items = lines
 	}
+	if t.Alerts != nil {
+		t.Alerts.Items = data.Alerts.Active(data.Services)
+		for i, service := range data.Services {
+			if data.Alerts.Down(service) {
+				t.Services.Items[i] = alertText(t.Services.Items[i])
+			}
+		}
+	}
+	if len(data.Services) > 1 && t.Selected < len(t.Services.Items) {
+		t.Services.Items[t.Selected] = "▶ " + t.Services.Items[t.Selected]
+	}
 
 	// Sparklines
 	for i, service := range data.Services {
@@ -136,9 +204,66 @@ func (t *TermUI) Update(data UIData) {
 	if t.Sparkline2 != nil {
 		widgets = append(widgets, t.Sparkline2)
 	}
+	if t.Alerts != nil {
+		widgets = append(widgets, t.Alerts)
+	}
 	termui.Render(widgets...)
 }
 
+// Overlay implements Overlayer.
+func (t *TermUI) Overlay() bool {
+	return t.Browser != nil || t.Zoom != nil
+}
+
+// HandleKey implements KeyHandler.
+//
+// Keys: up/down - select service, b - open vars browser for selected service,
+// tab - select var, z - open zoom view for selected var, p - capture
+// profiles of selected service.
+func (t *TermUI) HandleKey(key string, data *UIData) bool {
+	if t.Browser != nil {
+		if !t.Browser.HandleKey(key, data) {
+			t.Browser = nil
+			termui.Clear()
+		}
+		return true
+	}
+
+	switch key {
+	case "<Tab>":
+		t.SelectedVar = (t.SelectedVar + 1) % len(data.Vars)
+	case "z", "<Escape>":
+		if t.Zoom == nil && key == "z" {
+			t.Zoom = NewZoom()
+		} else {
+			t.Zoom = nil
+		}
+		termui.Clear()
+	case "<Up>", "k":
+		if t.Selected > 0 {
+			t.Selected--
+		}
+	case "<Down>", "j":
+		if t.Selected < len(data.Services)-1 {
+			t.Selected++
+		}
+	case "b":
+		if t.Selected >= len(data.Services) {
+			return false
+		}
+		t.Browser = NewBrowser(data.Services[t.Selected], data.Redraw)
+		termui.Clear()
+	case "p":
+		if data.Profiler == nil || t.Selected >= len(data.Services) {
+			return false
+		}
+		return data.Profiler.Capture(data.Services[t.Selected])
+	default:
+		return false
+	}
+	return true
+}
+
 // Relayout recalculates widgets sizes and coords.
 func (t *TermUI) Relayout() {
 	tw, th := termui.TermWidth(), termui.TermHeight()
@@ -185,6 +310,13 @@ func (t *TermUI) Relayout() {
 	}
 	h -= t.Lists[0].Height
 
+	// Optional row: active alerts
+	if t.Alerts != nil {
+		t.Alerts.Width = tw
+		t.Alerts.Y = th - h
+		h -= t.Alerts.Height
+	}
+
 	// Third row: sparklines for two vars
 	t.Sparkline1.Width = tw
 	t.Sparkline1.Height = h
@@ -210,22 +342,78 @@ func (t *TermUI) Close() {
 
 // StatusLine returns status line for service with it's name and status.
 func StatusLine(s *Service) string {
+	if !s.Vanished.IsZero() {
+		return fmt.Sprintf("[V] 💨 %s vanished", s.Name)
+	}
+
 	if s.Err != nil {
-		return fmt.Sprintf("[E] ⛔ %s failed", s.Name)
+		var line string
+		switch s.Status {
+		case StatusTimeout:
+			line = fmt.Sprintf("[T] ⏳ %s timed out", s.Name)
+		case StatusRefused:
+			line = fmt.Sprintf("[E] ⛔ %s refused", s.Name)
+		case StatusTLS:
+			reason, _ := tlsReason(s.Err)
+			line = fmt.Sprintf("[E] 🔒 %s TLS: %s", s.Name, reason)
+		default:
+			line = fmt.Sprintf("[E] ⛔ %s failed", s.Name)
+		}
+		if wait := time.Until(s.NextAttempt); wait > 0 {
+			line += fmt.Sprintf(", retry in %v", wait.Round(time.Second))
+		}
+		return line
 	}
 
 	if s.Restarted {
 		return fmt.Sprintf("[R] 🔥 %s", s.Name)
 	}
 
+	if s.Status == StatusSlow {
+		return fmt.Sprintf("[S] 🐢 %s slow (%v)", s.Name, roundDuration(s.Latency))
+	}
+
+	if s.Discovered && time.Since(s.Found) < discoveryMarkTTL {
+		return fmt.Sprintf("[N] ✨ %s", s.Name)
+	}
+
 	return fmt.Sprintf("[R] %s", s.Name)
 }
 
+// newAlertsList creates panel for active alerts.
+func newAlertsList() *termui.List {
+	list := termui.NewList()
+	list.ItemFgColor = termui.ColorRed | termui.AttrBold
+	list.Border = true
+	list.BorderLabel = "Alerts"
+	list.BorderLabelFg = termui.ColorRed | termui.AttrBold
+	list.Height = 5
+	return list
+}
+
+// equalVars returns true if both lists contain the same vars in the same order.
+func equalVars(a, b []VarName) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// alertText highlights text for the var or service with active alert.
+func alertText(s string) string {
+	return fmt.Sprintf("[%s](fg-red,fg-bold)", s)
+}
+
 func colorByKind(kind VarKind) termui.Attribute {
 	switch kind {
-	case KindMemory:
+	case KindMemory, KindMemorySI, KindMemoryIEC:
 		return termui.ColorRed | termui.AttrBold
-	case KindDuration:
+	case KindDuration, KindSeconds:
 		return termui.ColorYellow | termui.AttrBold
 	case KindString:
 		return termui.ColorGreen | termui.AttrBold
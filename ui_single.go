@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/gizak/termui"
 )
@@ -14,6 +13,16 @@ type TermUISingle struct {
 	Sparklines map[VarName]*termui.Sparkline
 	Sparkline  *termui.Sparklines
 	Pars       []*termui.Paragraph
+	Alerts     *termui.List
+	Goroutines *termui.List
+	Browser    *Browser
+	Zoom       *Zoom
+
+	// SelectedVar is an index of var, selected for zoom view.
+	SelectedVar int
+
+	// vars widgets were created for
+	vars []VarName
 }
 
 // Init creates widgets, sets sizes and labels.
@@ -23,6 +32,13 @@ func (t *TermUISingle) Init(data UIData) error {
 		return err
 	}
 
+	t.build(data)
+	return nil
+}
+
+// build creates widgets for the given vars.
+func (t *TermUISingle) build(data UIData) {
+	t.vars = append([]VarName{}, data.Vars...)
 	t.Sparklines = make(map[VarName]*termui.Sparkline)
 
 	t.Title = func() *termui.Paragraph {
@@ -44,24 +60,36 @@ func (t *TermUISingle) Init(data UIData) error {
 		return p
 	}()
 
+	labels := Labels(data.Vars)
 	t.Pars = make([]*termui.Paragraph, len(data.Vars))
 	for i, name := range data.Vars {
 		par := termui.NewParagraph("")
 		par.TextFgColor = colorByKind(name.Kind())
 		par.Border = true
-		par.BorderLabel = name.Short()
+		par.BorderLabel = labels[i]
 		par.BorderLabelFg = termui.ColorGreen
 		par.Height = 3
 		t.Pars[i] = par
 	}
 
+	if data.Alerts != nil {
+		t.Alerts = newAlertsList()
+	}
+	if data.Goroutines != nil {
+		t.Goroutines = termui.NewList()
+		t.Goroutines.ItemFgColor = termui.ColorWhite
+		t.Goroutines.Border = true
+		t.Goroutines.BorderLabelFg = termui.ColorGreen
+		t.Goroutines.Height = data.Goroutines.Top + 2
+	}
+
 	var sparklines []termui.Sparkline
 	for _, name := range data.Vars {
 		spl := termui.NewSparkline()
 		spl.Height = 1
 		spl.TitleColor = colorByKind(name.Kind())
 		spl.LineColor = colorByKind(name.Kind())
-		spl.Title = name.Long()
+		spl.Title = name.Title()
 		sparklines = append(sparklines, spl)
 	}
 
@@ -74,21 +102,62 @@ func (t *TermUISingle) Init(data UIData) error {
 	}()
 
 	t.Relayout()
-
-	return nil
 }
 
 // Update updates UI widgets from UIData.
 func (t *TermUISingle) Update(data UIData) {
-	// single mode assumes we have one service only to monitor
+	// vars may be added or removed in browser
+	if !equalVars(t.vars, data.Vars) {
+		termui.Clear()
+		t.build(data)
+		if t.SelectedVar >= len(data.Vars) {
+			t.SelectedVar = 0
+		}
+	}
+
+	// single mode assumes we have one service only to monitor,
+	// but with discovery it may be not found yet
+	if len(data.Services) == 0 {
+		t.Title.Text = "no services to monitor yet, press q to quit"
+		t.Status.Text = data.StatusText()
+		termui.Render(t.Title, t.Status)
+		return
+	}
 	service := data.Services[0]
 
-	t.Title.Text = fmt.Sprintf("monitoring %s every %v, press q to quit", service.Name, *interval)
-	t.Status.Text = fmt.Sprintf("Last update: %v", data.LastTimestamp.Format(time.Stamp))
+	t.Title.Text = fmt.Sprintf("monitoring %s every %v, press q to quit, b to browse vars, z to zoom, p to profile", service.Name, *interval)
+	t.Status.Text = data.StatusText()
+
+	if t.Browser != nil {
+		t.Browser.Update(data, t.Title.Height)
+		termui.Render(t.Title, t.Status, t.Browser.List)
+		return
+	}
+	if t.Zoom != nil {
+		t.Zoom.Update(data.Services[:1], data.Vars[t.SelectedVar], t.Title.Height)
+		termui.Render(append([]termui.Bufferer{t.Title, t.Status}, t.Zoom.Buffers()...)...)
+		return
+	}
 
 	// Pars
 	for i, name := range data.Vars {
 		t.Pars[i].Text = service.Value(name)
+		t.Pars[i].TextFgColor = colorByKind(name.Kind())
+		t.Pars[i].BorderFg = termui.ColorWhite
+		if i == t.SelectedVar {
+			t.Pars[i].BorderFg = termui.ColorCyan | termui.AttrBold
+		}
+		if data.Alerts != nil && data.Alerts.Firing(service, name) {
+			t.Pars[i].TextFgColor = termui.ColorRed | termui.AttrBold
+		}
+	}
+	if t.Alerts != nil {
+		t.Alerts.Items = data.Alerts.Active(data.Services[:1])
+	}
+	if t.Goroutines != nil {
+		lines, total := data.Goroutines.Lines()
+		t.Goroutines.Items = lines
+		t.Goroutines.BorderLabel = fmt.Sprintf("Goroutines: %d, top stacks (count, change)", total)
 	}
 
 	// Sparklines
@@ -96,7 +165,7 @@ func (t *TermUISingle) Update(data UIData) {
 		spl := &t.Sparkline.Lines[i]
 
 		max := formatMax(service.Max(name))
-		spl.Title = fmt.Sprintf("%s: %v%s", name.Long(), service.Value(name), max)
+		spl.Title = fmt.Sprintf("%s: %v%s", name.Title(), service.Value(name), max)
 		spl.TitleColor = colorByKind(name.Kind())
 		spl.LineColor = colorByKind(name.Kind())
 
@@ -113,6 +182,12 @@ func (t *TermUISingle) Update(data UIData) {
 	for _, par := range t.Pars {
 		widgets = append(widgets, par)
 	}
+	if t.Alerts != nil {
+		widgets = append(widgets, t.Alerts)
+	}
+	if t.Goroutines != nil {
+		widgets = append(widgets, t.Goroutines)
+	}
 	termui.Render(widgets...)
 }
 
@@ -121,6 +196,51 @@ func (t *TermUISingle) Close() {
 	termui.Close()
 }
 
+// Overlay implements Overlayer.
+func (t *TermUISingle) Overlay() bool {
+	return t.Browser != nil || t.Zoom != nil
+}
+
+// HandleKey implements KeyHandler.
+//
+// Keys: b - open vars browser, tab - select var, z - open zoom view
+// for selected var, p - capture profiles.
+func (t *TermUISingle) HandleKey(key string, data *UIData) bool {
+	if t.Browser != nil {
+		if !t.Browser.HandleKey(key, data) {
+			t.Browser = nil
+			termui.Clear()
+		}
+		return true
+	}
+
+	switch key {
+	case "<Tab>":
+		t.SelectedVar = (t.SelectedVar + 1) % len(data.Vars)
+	case "z", "<Escape>":
+		if t.Zoom == nil && key == "z" {
+			t.Zoom = NewZoom()
+		} else {
+			t.Zoom = nil
+		}
+		termui.Clear()
+	case "b":
+		if len(data.Services) == 0 {
+			return false
+		}
+		t.Browser = NewBrowser(data.Services[0], data.Redraw)
+		termui.Clear()
+	case "p":
+		if data.Profiler == nil || len(data.Services) == 0 {
+			return false
+		}
+		return data.Profiler.Capture(data.Services[0])
+	default:
+		return false
+	}
+	return true
+}
+
 // Relayout recalculates widgets sizes and coords.
 func (t *TermUISingle) Relayout() {
 	tw, th := termui.TermWidth(), termui.TermHeight()
@@ -153,6 +273,20 @@ func (t *TermUISingle) Relayout() {
 	}
 	h -= secondRowH
 
+	// Optional row: active alerts
+	if t.Alerts != nil {
+		t.Alerts.Width = tw
+		t.Alerts.Y = th - h
+		h -= t.Alerts.Height
+	}
+
+	// Optional row: goroutine stacks
+	if t.Goroutines != nil {
+		t.Goroutines.Width = tw
+		t.Goroutines.Y = th - h
+		h -= t.Goroutines.Height
+	}
+
 	// Third row: Sparklines
 	t.Sparkline.Width = tw
 	t.Sparkline.Height = h
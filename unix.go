@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// UnixScheme is URL scheme of services, serving vars on Unix socket.
+//
+// Socket path and HTTP endpoint are separated by colon, like
+// "unix:///run/app.sock:/debug/vars". Endpoint may be omitted.
+const UnixScheme = "unix"
+
+// unixHost is used as HTTP host for requests via Unix socket.
+const unixHost = "localhost"
+
+// UnixURL returns URL for the socket and HTTP endpoint.
+func UnixURL(socket, endpoint string) url.URL {
+	return url.URL{
+		Scheme: UnixScheme,
+		Path:   socket + ":" + endpoint,
+	}
+}
+
+// UnixSocket returns socket path and HTTP endpoint of Unix socket URL.
+func UnixSocket(u url.URL) (string, string, bool) {
+	if strings.TrimPrefix(u.Scheme, PrometheusSchemePrefix) != UnixScheme {
+		return "", "", false
+	}
+	socket, endpoint := u.Path, ""
+	if i := strings.Index(socket, ":"); i >= 0 {
+		socket, endpoint = socket[:i], socket[i+1:]
+	}
+	return socket, endpoint, true
+}
+
+// parseUnixTarget parses Unix socket target, expanding
+// glob patterns in socket path, like "unix:///run/apps/*.sock".
+func parseUnixTarget(s, endpoint string) ([]url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	socket, ep, _ := UnixSocket(*u)
+	if socket == "" {
+		return nil, fmt.Errorf("no socket path in %q", s)
+	}
+	if ep != "" {
+		endpoint = ep
+	}
+
+	sockets := []string{socket}
+	if strings.ContainsAny(socket, "*?[") {
+		sockets, err = filepath.Glob(socket)
+		if err != nil {
+			return nil, err
+		}
+		if len(sockets) == 0 {
+			return nil, fmt.Errorf("no sockets found for %s", socket)
+		}
+	}
+
+	var urls []url.URL
+	for _, socket := range sockets {
+		su := UnixURL(socket, endpoint)
+		su.RawQuery = u.RawQuery
+		urls = append(urls, su)
+	}
+	return urls, nil
+}
+
+// unixHTTPURL returns HTTP URL for requests via Unix socket.
+func unixHTTPURL(u url.URL) url.URL {
+	_, endpoint, _ := UnixSocket(u)
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	return url.URL{
+		Scheme:   "http",
+		Host:     unixHost,
+		Path:     endpoint,
+		RawQuery: u.RawQuery,
+	}
+}
+
+// unixTransport returns transport, dialing to the socket.
+func unixTransport(base http.RoundTripper, socket string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport := base.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+	return transport
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUnixTargets(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.sock", "b.sock"} {
+		l, err := net.Listen("unix", filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+	}
+
+	urls, err := ParsePorts("unix://" + dir + "/*.sock,prom+unix:///run/app.sock,unix:///run/app.sock:/_vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"unix://" + dir + "/a.sock:/debug/vars",
+		"unix://" + dir + "/b.sock:/debug/vars",
+		"prom+unix:///run/app.sock:/metrics",
+		"unix:///run/app.sock:/_vars",
+	}
+	if len(urls) != len(want) {
+		t.Fatalf("Expecting %d URLs, but got %v", len(want), urls)
+	}
+	for i, u := range urls {
+		if u.String() != want[i] {
+			t.Fatalf("Expecting URL %q, but got %q", want[i], u.String())
+		}
+	}
+
+	if _, err := ParsePorts("unix://" + dir + "/*.nope"); err == nil {
+		t.Fatalf("Expecting glob without matches to fail")
+	}
+}
+
+func TestUnixFetch(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/vars" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"goroutines": 10}`))
+	}))
+	defer l.Close()
+
+	urls, _ := ParsePorts("unix://" + socket)
+	service := NewService(urls[0], []VarName{"goroutines"})
+	if service.Name != "app.sock" {
+		t.Fatalf("Expecting socket file name to be used as service name, but got %q", service.Name)
+	}
+
+	expvar, err := service.Source.Fetch(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := expvar.GetInt64("goroutines"); v != 10 {
+		t.Fatalf("Expecting goroutines to be 10, but got %d", v)
+	}
+
+	l.Close()
+	_, err = FetchExpvar(urls[0])
+	if err == nil || !strings.Contains(err.Error(), "unix://"+socket) {
+		t.Fatalf("Expecting fetch from closed socket to fail with socket URL, but got %v", err)
+	}
+}
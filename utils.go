@@ -21,12 +21,30 @@ func ParseVars(vars string) ([]VarName, error) {
 
 	ss := strings.FieldsFunc(vars, func(r rune) bool { return r == ',' })
 	var ret []VarName
-	for _, s := range ss {
-		ret = append(ret, VarName(s))
+	for i := 0; i < len(ss); i++ {
+		// modifier parameters may be separated by commas as well,
+		// so "scale:1e-6,unit:ms:Var" is "scale:1e-6:unit:ms:Var"
+		s := ss[i]
+		for i+1 < len(ss) && modifiersOnly(s) {
+			i++
+			s += ":" + ss[i]
+		}
+		name := VarName(s)
+		if err := name.Validate(); err != nil {
+			return nil, err
+		}
+		ret = append(ret, name)
 	}
 	return ret, nil
 }
 
+// modifiersOnly returns true if s is a list of modifiers without
+// var path, ending with modifier parameter, like "scale:1e-6".
+func modifiersOnly(s string) bool {
+	mods, path := VarName(s + ":").split()
+	return path == "" && len(mods) > 0 && strings.Contains(mods[len(mods)-1], ":")
+}
+
 // BaseCommand returns cleaned command name from Cmdline array.
 //
 // I.e. "./some.service/binary.name -arg 1 -arg" will be "binary.name".
@@ -37,10 +55,11 @@ func BaseCommand(cmdline []string) string {
 	return filepath.Base(cmdline[0])
 }
 
-// flattenURLs returns URLs for the given addr and set of ports.
+// flattenURLs returns URLs for the given addr and set of ports,
+// with endpoint path used if rawurl has no path.
 //
 // Note, rawurl shouldn't contain port, as port will be appended.
-func flattenURLs(rawurl string, ports []string) ([]url.URL, error) {
+func flattenURLs(rawurl string, ports []string, endpoint string) ([]url.URL, error) {
 	var urls []url.URL
 
 	// Add http by default
@@ -54,7 +73,7 @@ func flattenURLs(rawurl string, ports []string) ([]url.URL, error) {
 		return nil, err
 	}
 	if baseURL.Path == "" {
-		baseURL.Path = DefaultEndpoint
+		baseURL.Path = endpoint
 	}
 
 	// Create new URL for each port
@@ -71,6 +90,40 @@ func ParsePorts(s string) ([]url.URL, error) {
 	var urls []url.URL
 	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' })
 	for _, field := range fields {
+		// "prom+" prefix marks Prometheus metrics endpoints
+		prom := strings.HasPrefix(field, PrometheusSchemePrefix)
+		field = strings.TrimPrefix(field, PrometheusSchemePrefix)
+
+		if field == StdinTarget {
+			urls = append(urls, url.URL{Path: StdinTarget})
+			continue
+		}
+		if strings.HasPrefix(field, FileScheme+":") {
+			purls, err := parseFileTarget(field)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, purls...)
+			continue
+		}
+		endpoint := DefaultEndpoint
+		if prom {
+			endpoint = PrometheusEndpoint
+		}
+		if strings.HasPrefix(field, UnixScheme+":") {
+			purls, err := parseUnixTarget(field, endpoint)
+			if err != nil {
+				return nil, err
+			}
+			for i := range purls {
+				if prom {
+					purls[i].Scheme = PrometheusSchemePrefix + purls[i].Scheme
+				}
+			}
+			urls = append(urls, purls...)
+			continue
+		}
+
 		rawurl, portsRange := extractURLAndPorts(field)
 
 		ports, err := parseRange(portsRange)
@@ -78,10 +131,15 @@ func ParsePorts(s string) ([]url.URL, error) {
 			return nil, ErrParsePorts
 		}
 
-		purls, err := flattenURLs(rawurl, ports)
+		purls, err := flattenURLs(rawurl, ports, endpoint)
 		if err != nil {
 			return nil, ErrParsePorts
 		}
+		if prom {
+			for i := range purls {
+				purls[i].Scheme = PrometheusSchemePrefix + purls[i].Scheme
+			}
+		}
 
 		urls = append(urls, purls...)
 	}
@@ -147,3 +205,17 @@ func NewURL(port string) url.URL {
 		Path:   "/debug/vars",
 	}
 }
+
+// stringsFlag is a repeatable string flag.
+type stringsFlag []string
+
+// String implements flag.Value.
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+// Set implements flag.Value.
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestUtils(t *testing.T) {
 	str := "memstats.Alloc,memstats.Sys"
@@ -24,6 +27,16 @@ func TestUtils(t *testing.T) {
 	if len(vars) != 4 {
 		t.Fatalf("vars should contain 4 elements, but has %d", len(vars))
 	}
+
+	// modifier parameters may be separated by commas
+	vars, err = ParseVars("GC=scale:1e-6,unit:ms:memstats.PauseTotalNs,scale:2,unit:s,Uptime,mem")
+	if err != nil {
+		t.Fatalf("Err not nil: %v", err)
+	}
+	want := []VarName{"GC=scale:1e-6:unit:ms:memstats.PauseTotalNs", "scale:2:unit:s:Uptime", "mem"}
+	if !reflect.DeepEqual(vars, want) {
+		t.Fatalf("vars should be %v, but got %v", want, vars)
+	}
 }
 
 func TestExtractUrlAndPorts(t *testing.T) {
@@ -118,4 +131,13 @@ func TestPorts(t *testing.T) {
 	if ports[0].Path != "/debug/vars" || ports[1].Path != "/_custom_expvars" {
 		t.Fatalf("ParsePorts returns wrong data: %v", ports)
 	}
+	// Prometheus endpoints are used only if path is not set
+	arg = "prom+localhost:2000,prom+https://example.com:1234/debug/vars,prom+http://metrics:9100/"
+	ports, err = ParsePorts(arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ports[0].Path != "/metrics" || ports[1].Path != "/debug/vars" || ports[2].Path != "/" {
+		t.Fatalf("ParsePorts returns wrong data: %v", ports)
+	}
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,7 +15,12 @@ import (
 // It has dot-separated format, like "memstats.Alloc",
 // but can be used in different forms, hence it's own type.
 //
-// It also can have optional "kind:" modifier, like "mem:" or "duration:"
+// It also can have optional "kind:" modifier, like "mem:" or "duration:",
+// array reducer, like "max:" or "p99:",
+// and "rate:" modifier, which can be combined with kind, like "rate:mem:".
+// Modifiers "scale:X:" and "unit:U:" affect displayed values.
+// Computed vars have "expr:" modifier, followed by expression. Vars
+// may be named with alias, like "live=expr:memstats.Mallocs-memstats.Frees".
 type VarName string
 
 // VarKind specifies special kinds of values, affects formatting.
@@ -27,8 +34,114 @@ const (
 	KindMemory
 	KindDuration
 	KindString
+	KindMemorySI
+	KindMemoryIEC
+	KindSeconds
+	KindCount
+	KindThousands
 )
 
+// modifiers lists all known "modifier:" prefixes for var names.
+var modifiers = map[string]bool{
+	"mem":      true,
+	"memsi":    true,
+	"memiec":   true,
+	"duration": true,
+	"seconds":  true,
+	"count":    true,
+	"sep":      true,
+	"str":      true,
+	"rate":     true,
+	"expr":     true,
+	"scale":    true,
+	"unit":     true,
+}
+
+// kindModifiers maps kind modifiers to kinds.
+var kindModifiers = map[string]VarKind{
+	"mem":      KindMemory,
+	"memsi":    KindMemorySI,
+	"memiec":   KindMemoryIEC,
+	"duration": KindDuration,
+	"seconds":  KindSeconds,
+	"count":    KindCount,
+	"sep":      KindThousands,
+	"str":      KindString,
+}
+
+// paramModifiers lists modifiers with parameter, like "scale:1e-6:"
+// or "unit:ms:". They're returned by split along with parameter.
+var paramModifiers = map[string]bool{
+	"scale": true,
+	"unit":  true,
+}
+
+// split separates leading alias and modifiers from the var path.
+// Everything after "expr:" modifier is an expression.
+//
+// Example: "rate:mem:memstats.TotalAlloc" => []string{"rate", "mem"}, "memstats.TotalAlloc"
+// Example: "scale:1e-6:unit:ms:Latency" => []string{"scale:1e-6", "unit:ms"}, "Latency"
+func (v VarName) split() ([]string, string) {
+	var mods []string
+	_, s := v.alias()
+	for {
+		idx := strings.IndexRune(s, ':')
+		if idx == -1 || !modifiers[s[:idx]] && !isReducer(s[:idx]) {
+			break
+		}
+		mod := s[:idx]
+		s = s[idx+1:]
+		if paramModifiers[mod] {
+			idx = strings.IndexRune(s, ':')
+			if idx == -1 {
+				// no parameter, reported by Validate
+				mods = append(mods, mod)
+				break
+			}
+			mod += ":" + s[:idx]
+			s = s[idx+1:]
+		}
+		mods = append(mods, mod)
+		if mod == ExprModifier {
+			break
+		}
+	}
+	return mods, s
+}
+
+// alias separates leading "alias=" from the rest of var name.
+// Alias is an identifier, so "=" in paths and label matchers
+// is not confused with it.
+func (v VarName) alias() (string, string) {
+	s := string(v)
+	idx := strings.IndexByte(s, '=')
+	if idx <= 0 || !isIdentStart(s[0]) {
+		return "", s
+	}
+	for i := 1; i < idx; i++ {
+		c := s[i]
+		if !isIdentStart(c) && (c < '0' || c > '9') && c != '-' {
+			return "", s
+		}
+	}
+	return s[:idx], s[idx+1:]
+}
+
+// WithModifier returns var name with modifier added after alias, if any.
+func (v VarName) WithModifier(mod string) VarName {
+	alias, s := v.alias()
+	if alias != "" {
+		return VarName(alias + "=" + mod + ":" + s)
+	}
+	return VarName(mod + ":" + s)
+}
+
+// Alias returns alias of the var, if it's named ("heap=mem:memstats.HeapAlloc").
+func (v VarName) Alias() string {
+	alias, _ := v.alias()
+	return alias
+}
+
 // ToSlice converts "dot-separated" notation into the "slice of strings".
 //
 // "dot-separated" notation is a human-readable format, passed via args.
@@ -37,51 +150,196 @@ const (
 // Example: "memstats.Alloc" => []string{"memstats", "Alloc"}
 // Example: "mem:memstats.Alloc" => []string{"memstats", "Alloc"}
 func (v VarName) ToSlice() []string {
-	start := strings.IndexRune(string(v), ':') + 1
-	slice := DottedFieldsToSliceEscaped(string(v)[start:])
-	return slice
+	_, path := v.split()
+	return DottedFieldsToSliceEscaped(path)
 }
 
 // Short returns short name, which is typically is the last word in the long names.
+// For values in arrays, it starts from the last indexed word, like "BySize[3].Mallocs".
+// Named vars and computed vars use alias and expression instead.
 func (v VarName) Short() string {
+	s, _ := v.short(0)
+	return s
+}
+
+// short returns short name, extended with extra parent keys, and
+// false if it can't be extended anymore.
+func (v VarName) short(extra int) (string, bool) {
 	if v == "" {
-		return ""
+		return "", false
+	}
+	alias := v.Alias()
+	if alias != "" && (extra == 0 || v.Computed()) {
+		return alias, !v.Computed()
+	}
+	if v.Computed() {
+		return v.Long(), false
+	}
+	if alias != "" {
+		// named vars with the same alias, like expanded
+		// wildcard var, are labeled with keys as well
+		s, more := VarName(v.Long()).short(extra - 1)
+		return alias + " " + s, more
 	}
 
 	slice := v.ToSlice()
-	return slice[len(slice)-1]
+	start := len(slice) - 1
+	for i := start; i >= 0; i-- {
+		if seg, err := parseSegment(slice[i]); err == nil && len(seg.Indexes) > 0 {
+			start = i
+			break
+		}
+	}
+	start -= extra
+	if start <= 0 {
+		return strings.Join(slice, "."), false
+	}
+	return strings.Join(slice[start:], "."), true
+}
+
+// Labels returns display labels for the vars: aliases or short names,
+// extended with parent keys while they collide, so "memstats.Alloc" and
+// "cache.Alloc" are labeled as such instead of two "Alloc". Vars, which
+// differ only by modifiers, are labeled with full names.
+func Labels(vars []VarName) []string {
+	labels := make([]string, len(vars))
+	extra := make([]int, len(vars))
+	more := make([]bool, len(vars))
+	for i, name := range vars {
+		labels[i], more[i] = name.short(0)
+	}
+
+	collisions := func() [][]int {
+		seen := make(map[string][]int)
+		for i, label := range labels {
+			seen[label] = append(seen[label], i)
+		}
+		var ret [][]int
+		for _, idx := range seen {
+			if len(idx) > 1 {
+				ret = append(ret, idx)
+			}
+		}
+		return ret
+	}
+
+	for extended := true; extended; {
+		extended = false
+		for _, idx := range collisions() {
+			for _, i := range idx {
+				if more[i] {
+					extra[i]++
+					labels[i], more[i] = vars[i].short(extra[i])
+					extended = true
+				}
+			}
+		}
+	}
+	for _, idx := range collisions() {
+		for _, i := range idx {
+			if vars[i].Alias() == "" {
+				labels[i] = string(vars[i])
+			}
+		}
+	}
+	return labels
 }
 
-// Long returns long name, without kind: modifier.
+// Title returns name to be used in titles: alias for named vars,
+// or long name otherwise.
+func (v VarName) Title() string {
+	if alias := v.Alias(); alias != "" {
+		return alias
+	}
+	return v.Long()
+}
+
+// Long returns long name, without alias and kind: modifier.
 func (v VarName) Long() string {
 	if v == "" {
 		return ""
 	}
 
-	start := strings.IndexRune(string(v), ':') + 1
-	return string(v)[start:]
+	_, path := v.split()
+	return path
 }
 
-// Kind returns kind of variable, based on it's name modifiers ("mem:")
+// Kind returns kind of variable, based on it's name modifiers ("mem:").
+// Fetch pseudo-vars have their own default kinds.
 func (v VarName) Kind() VarKind {
-	start := strings.IndexRune(string(v), ':')
-	if start == -1 {
-		return KindDefault
+	mods, _ := v.split()
+	for _, mod := range mods {
+		if kind, ok := kindModifiers[mod]; ok {
+			return kind
+		}
 	}
-
-	switch string(v)[:start] {
-	case "mem":
-		return KindMemory
-	case "duration":
-		return KindDuration
-	case "str":
-		return KindString
+	if field, ok := v.fetchVar(); ok {
+		return fetchVars[field]
 	}
 	return KindDefault
 }
 
+// Rate returns true if var should be displayed as per-second rate
+// of change, rather than raw value ("rate:" modifier).
+func (v VarName) Rate() bool {
+	mods, _ := v.split()
+	for _, mod := range mods {
+		if mod == "rate" {
+			return true
+		}
+	}
+	return false
+}
+
+// param returns parameter of the modifier ("ms" for "unit:ms:").
+func (v VarName) param(name string) (string, bool) {
+	mods, _ := v.split()
+	for _, mod := range mods {
+		if strings.HasPrefix(mod, name+":") {
+			return mod[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// Scale returns multiplier of displayed values ("scale:1e-6:" modifier).
+func (v VarName) Scale() (float64, bool) {
+	s, ok := v.param("scale")
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// Unit returns unit suffix of displayed values ("unit:ms:" modifier).
+func (v VarName) Unit() string {
+	unit, _ := v.param("unit")
+	return unit
+}
+
+// Format returns human-readable representation of var value,
+// respecting all name modifiers.
+func (v VarName) Format(val VarValue) string {
+	if scale, ok := v.Scale(); ok {
+		if f, ok := toFloat64(val); ok {
+			val = f * scale
+		}
+	}
+	str := Format(val, v.Kind()) + v.Unit()
+	if v.Rate() {
+		str += "/s"
+	}
+	return str
+}
+
 // Format returns human-readable var value representation.
 func Format(v VarValue, kind VarKind) string {
+	// rates are float64, but memory and duration are int64 by nature
+	if f, ok := v.(float64); ok && (kind == KindMemory || kind == KindDuration) {
+		v = int64(f)
+	}
+
 	switch kind {
 	case KindMemory:
 		if _, ok := v.(int64); !ok {
@@ -93,6 +351,34 @@ func Format(v VarValue, kind VarKind) string {
 			break
 		}
 		return fmt.Sprintf("%s", roundDuration(time.Duration(v.(int64))))
+	case KindSeconds:
+		if f, ok := toFloat64(v); ok {
+			// keep fractions of seconds, like "1.5s"
+			d := time.Duration(f * float64(time.Second))
+			if d < time.Second && d > -time.Second {
+				return roundDuration(d).String()
+			}
+			return d.Round(10 * time.Millisecond).String()
+		}
+	case KindMemorySI:
+		if f, ok := toFloat64(v); ok {
+			return formatUnits(f, 1000, "B", []string{"kB", "MB", "GB", "TB", "PB", "EB"})
+		}
+	case KindMemoryIEC:
+		if f, ok := toFloat64(v); ok {
+			return formatUnits(f, 1024, "B", []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"})
+		}
+	case KindCount:
+		if f, ok := toFloat64(v); ok {
+			return formatUnits(f, 1000, "", []string{"k", "M", "G", "T", "P", "E"})
+		}
+	case KindThousands:
+		switch n := v.(type) {
+		case int64:
+			return groupThousands(strconv.FormatInt(n, 10))
+		case float64:
+			return groupThousands(fmt.Sprintf("%.2f", n))
+		}
 	}
 
 	if f, ok := v.(float64); ok {
@@ -102,6 +388,45 @@ func Format(v VarValue, kind VarKind) string {
 	return fmt.Sprintf("%v", v)
 }
 
+// formatUnits formats value with the largest unit, which keeps it
+// above 1, like "1.2k" or "3.4MB". Values below base are kept as is.
+func formatUnits(f, base float64, unit string, units []string) string {
+	abs := math.Abs(f)
+	if abs < base {
+		if f == math.Trunc(f) {
+			return fmt.Sprintf("%.0f%s", f, unit)
+		}
+		return fmt.Sprintf("%.2f%s", f, unit)
+	}
+	i := 0
+	for abs /= base; abs >= base && i < len(units)-1; abs /= base {
+		f /= base
+		i++
+	}
+	f /= base
+	if math.Abs(f) < 10 {
+		return fmt.Sprintf("%.1f%s", f, units[i])
+	}
+	return fmt.Sprintf("%.0f%s", f, units[i])
+}
+
+// groupThousands inserts thousands separators into the formatted
+// number, like "1,234,567.89".
+func groupThousands(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	frac := ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s, frac = s[:i], s[i:]
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return sign + s + frac
+}
+
 // roundDuration removes unneeded precision from the String() output for time.Duration.
 func roundDuration(d time.Duration) time.Duration {
 	r := time.Second
@@ -129,11 +454,39 @@ func roundDuration(d time.Duration) time.Duration {
 	return d
 }
 
+// DottedFieldsToSliceEscaped splits dot-separated notation into
+// the slice of fields, respecting escaped dots ("\.") and keeping
+// label matchers in curly braces (`http_requests_total{path="/a.b"}`) and
+// array indexes in square brackets (`BySize[?Name=="a.b"]`) intact.
 func DottedFieldsToSliceEscaped(s string) []string {
 	rv := make([]string, 0)
 	lastSlash := false
 	curr := ""
+	var closing rune
+	var quoted, escaped bool
 	for _, r := range s {
+		if closing != 0 {
+			curr += string(r)
+			switch {
+			case escaped:
+				escaped = false
+			case quoted && r == '\\':
+				escaped = true
+			case r == '"':
+				quoted = !quoted
+			case !quoted && r == closing:
+				closing = 0
+			}
+			continue
+		}
+		if !lastSlash && (r == '{' || r == '[') {
+			closing = '}'
+			if r == '[' {
+				closing = ']'
+			}
+			curr += string(r)
+			continue
+		}
 		// base case, dot not after slash
 		if !lastSlash && r == '.' {
 			if len(curr) > 0 {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +47,22 @@ func TestVarName(t *testing.T) {
 		t.Fatalf("Expecting kind to be %v, but got: %v", KindDuration, kind)
 	}
 
+	v = VarName("rate:mem:memstats.TotalAlloc")
+	if v.Kind() != KindMemory || !v.Rate() || v.Long() != "memstats.TotalAlloc" {
+		t.Fatalf("Expecting rate:mem: modifiers to be parsed, but got: %v, %v, %s", v.Kind(), v.Rate(), v.Long())
+	}
+	if str := v.Format(2048.0); str != "2.0KB/s" {
+		t.Fatalf("Expecting Format() to be '2.0KB/s', but got: %s", str)
+	}
+
+	// label matchers are kept intact
+	v = VarName(`http_requests_total{path="/a.b",code="5\".."}`)
+
+	slice = v.ToSlice()
+	if len(slice) != 1 || slice[0] != string(v) {
+		t.Fatalf("ToSlice failed: %v", slice)
+	}
+
 	// single \. escapes the dot
 	v = VarName(`bleve.indexes.bench\.bleve.index.lookup_queue_len`)
 
@@ -90,3 +108,90 @@ func TestVarName(t *testing.T) {
 		t.Fatalf("ToSlice failed: %v", slice)
 	}
 }
+
+func TestVarLabels(t *testing.T) {
+	v := VarName("Heap=mem:memstats.HeapAlloc")
+	if v.Short() != "Heap" || v.Title() != "Heap" || v.Kind() != KindMemory || v.Long() != "memstats.HeapAlloc" {
+		t.Fatalf("Expecting alias to be used as label, but got %q, %q, %v, %q", v.Short(), v.Title(), v.Kind(), v.Long())
+	}
+
+	vars := []VarName{
+		"mem:memstats.Alloc",
+		"cache.Alloc",
+		"Heap=memstats.HeapAlloc",
+		"app.db.pool.Size",
+		"app.http.pool.Size",
+		"memstats.BySize[1].Mallocs",
+		"Goroutines",
+		"rate:Goroutines",
+		"req=http.requests./api",
+		"req=http.requests./health",
+	}
+	want := []string{
+		"memstats.Alloc",
+		"cache.Alloc",
+		"Heap",
+		"db.pool.Size",
+		"http.pool.Size",
+		"BySize[1].Mallocs",
+		"Goroutines",
+		"rate:Goroutines",
+		"req /api",
+		"req /health",
+	}
+	if got := Labels(vars); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expecting labels to be %q, but got %q", want, got)
+	}
+}
+
+func TestVarScaleUnit(t *testing.T) {
+	v := VarName("scale:1e-6:unit:ms:memstats.PauseTotalNs")
+	if v.Long() != "memstats.PauseTotalNs" || v.Unit() != "ms" {
+		t.Fatalf("Expecting scale and unit to be parsed, but got %q, %q", v.Long(), v.Unit())
+	}
+	if s := v.Format(int64(1500000)); s != "1.50ms" {
+		t.Fatalf("Expecting scaled value to be 1.50ms, but got %q", s)
+	}
+	if s := VarName("rate:unit:req:http.requests").Format(2.5); s != "2.50req/s" {
+		t.Fatalf("Expecting unit before rate suffix, but got %q", s)
+	}
+	if err := VarName("scale:x:Latency").Validate(); err == nil || !strings.Contains(err.Error(), `invalid scale "x"`) {
+		t.Fatalf("Expecting invalid scale error, but got %v", err)
+	}
+	if err := VarName("unit:Latency").Validate(); err == nil || !strings.Contains(err.Error(), "no value for unit: modifier") {
+		t.Fatalf("Expecting missing unit error, but got %v", err)
+	}
+}
+
+func TestFormatKinds(t *testing.T) {
+	tests := []struct {
+		value VarValue
+		kind  VarKind
+		want  string
+	}{
+		{1.5, KindSeconds, "1.5s"},
+		{0.0042, KindSeconds, "4ms"},
+		{int64(90), KindSeconds, "1m30s"},
+		{int64(999), KindCount, "999"},
+		{int64(1234), KindCount, "1.2k"},
+		{3.4e6, KindCount, "3.4M"},
+		{int64(-25000), KindCount, "-25k"},
+		{int64(1500), KindMemorySI, "1.5kB"},
+		{int64(1536), KindMemoryIEC, "1.5KiB"},
+		{int64(5 << 30), KindMemoryIEC, "5.0GiB"},
+		{int64(800), KindMemoryIEC, "800B"},
+		{int64(1234567), KindThousands, "1,234,567"},
+		{-1234.5, KindThousands, "-1,234.50"},
+		{int64(123), KindThousands, "123"},
+		{"text", KindCount, "text"},
+	}
+	for _, test := range tests {
+		if got := Format(test.value, test.kind); got != test.want {
+			t.Fatalf("Expecting %v of kind %v to be formatted as %q, but got %q", test.value, test.kind, test.want, got)
+		}
+	}
+
+	if v := VarName("count:Requests"); v.Kind() != KindCount {
+		t.Fatalf("Expecting count: modifier to set kind, but got %v", v.Kind())
+	}
+}
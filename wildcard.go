@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antonholmquist/jason"
+)
+
+// pathSegment is a parsed segment of var path: object key, optionally
+// followed by array indexes, like "BySize[3]" or "BySize[*]".
+//
+// Key may contain "*" wildcards, matching any part of key, and
+// index may be "*", matching all array elements. Escaped "\*"
+// matches "*" in key literally.
+type pathSegment struct {
+	Key     string
+	Indexes []index
+
+	// Pattern is a key pattern for matchWildcard,
+	// or empty if key has no wildcards
+	Pattern string
+}
+
+// keyUnescaper unescapes selector metacharacters in keys.
+var keyUnescaper = strings.NewReplacer(`\[`, "[", `\]`, "]", `\{`, "{", `\}`, "}", `\*`, "*")
+
+// parseSegment parses path segment. Indexes start from the first
+// unescaped bracket outside of Prometheus label matchers, if any.
+func parseSegment(s string) (pathSegment, error) {
+	var seg pathSegment
+	i := indexStart(s)
+	seg.Key = keyUnescaper.Replace(s[:i])
+	seg.Pattern = keyPattern(s[:i])
+
+	for s = s[i:]; s != ""; {
+		if s[0] != '[' {
+			return seg, fmt.Errorf("unexpected %q after index in %q", s, seg.Key)
+		}
+		end := indexEnd(s)
+		if end < 0 {
+			return seg, fmt.Errorf("unclosed bracket in %q", s)
+		}
+		ix, err := parseIndex(s[1:end])
+		if err != nil {
+			return seg, err
+		}
+		seg.Indexes = append(seg.Indexes, ix)
+		s = s[end+1:]
+	}
+	return seg, nil
+}
+
+// indexStart returns position of the first index in segment,
+// or length of segment, if there are no indexes.
+func indexStart(s string) int {
+	var braces, quoted, escaped bool
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case braces && c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '{':
+			braces = true
+		case c == '}':
+			braces = false
+		case !braces && c == '[':
+			return i
+		}
+	}
+	return len(s)
+}
+
+// keyPattern returns pattern for the key with unescaped "*"
+// wildcards outside of Prometheus label matchers, or empty string
+// if there are none. Literal "*" are escaped in pattern.
+func keyPattern(s string) string {
+	var b strings.Builder
+	var braces, quoted, escaped, wildcard bool
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+			if c != '*' && strings.IndexByte("[]{}", c) < 0 {
+				b.WriteByte('\\')
+			}
+		case c == '\\':
+			escaped = true
+			continue
+		case braces && c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '{':
+			braces = true
+		case c == '}':
+			braces = false
+		case !braces && c == '*':
+			wildcard = true
+			b.WriteByte(c)
+			continue
+		}
+		if c == '*' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	if !wildcard {
+		return ""
+	}
+	return b.String()
+}
+
+// indexEnd returns position of bracket, closing index at
+// the start of s, skipping quoted strings.
+func indexEnd(s string) int {
+	var quoted, escaped bool
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// wildcard returns true if segment matches more than one value.
+func (s pathSegment) wildcard() bool {
+	if s.Pattern != "" {
+		return true
+	}
+	for _, index := range s.Indexes {
+		if index.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// usesFields returns true if segment indexes refer to fields
+// of the object, holding the segment.
+func (s pathSegment) usesFields() bool {
+	for _, index := range s.Indexes {
+		if index.usesFields() {
+			return true
+		}
+	}
+	return false
+}
+
+// Wildcard returns true if var name has wildcard segments, like
+// "http.requests.*" or "memstats.BySize[*].Mallocs". Such vars
+// are expanded into separate vars for every matched key.
+func (v VarName) Wildcard() bool {
+	if v.Computed() {
+		return false
+	}
+	for _, s := range v.ToSlice() {
+		if seg, err := parseSegment(s); err == nil && seg.wildcard() {
+			return true
+		}
+	}
+	return false
+}
+
+// staticPath returns the longest prefix of path without wildcards
+// and array indexes, i.e. path of the data needed to resolve the var.
+// Indexes using fields of the object need the whole object.
+func staticPath(path []string) []string {
+	static := make([]string, 0, len(path))
+	for _, s := range path {
+		seg, err := parseSegment(s)
+		if err != nil || seg.Pattern != "" || seg.usesFields() {
+			return static
+		}
+		// keys are unescaped, as in data
+		static = append(static, seg.Key)
+		if len(seg.Indexes) > 0 {
+			return static
+		}
+	}
+	return static
+}
+
+// Lookup returns value at the path in expvar data, resolving
+// array indexes, like "BySize[3]".
+func (e *Expvar) Lookup(path ...string) (*jason.Value, error) {
+	if len(path) == 0 {
+		return nil, errors.New("empty path")
+	}
+
+	obj := e.Object
+	var value *jason.Value
+	for i, s := range path {
+		if i > 0 {
+			var err error
+			if obj, err = value.Object(); err != nil {
+				return nil, err
+			}
+		}
+
+		seg, err := parseSegment(s)
+		if err != nil {
+			return nil, err
+		}
+		parent := obj
+		if value, err = obj.GetValue(seg.Key); err != nil {
+			return nil, err
+		}
+		for _, index := range seg.Indexes {
+			arr, err := value.Array()
+			if err != nil {
+				return nil, err
+			}
+			if index.wildcard {
+				return nil, fmt.Errorf("wildcard index of %s", seg.Key)
+			}
+			n, err := index.resolve(arr, parent)
+			if err != nil {
+				return nil, err
+			}
+			value = arr[n]
+		}
+	}
+	return value, nil
+}
+
+// ExpandVar expands wildcard var into vars for all matched keys in
+// expvar data, sorted by key and keeping var alias and modifiers.
+// At most limit vars are returned.
+func ExpandVar(e *Expvar, name VarName, limit int) []VarName {
+	return expandVar(e, name, limit, nil)
+}
+
+// expandVar expands wildcard var like ExpandVar, skipping known
+// vars, which are not counted to the limit. Walk of data is stopped
+// once limit is reached.
+func expandVar(e *Expvar, name VarName, limit int, known map[VarName]bool) []VarName {
+	mods, path := name.split()
+	var segs []pathSegment
+	for _, s := range DottedFieldsToSliceEscaped(path) {
+		seg, err := parseSegment(s)
+		if err != nil {
+			return nil
+		}
+		segs = append(segs, seg)
+	}
+	if len(segs) == 0 || e == nil || e.Object == nil {
+		return nil
+	}
+
+	prefix := ""
+	if alias := name.Alias(); alias != "" {
+		prefix = alias + "="
+	}
+	for _, mod := range mods {
+		prefix += mod + ":"
+	}
+
+	x := &expander{limit: limit}
+	if known != nil {
+		x.skip = func(p string) bool { return known[VarName(prefix+p)] }
+	}
+	x.object(e.Object, segs, nil)
+
+	vars := make([]VarName, len(x.paths))
+	for i, p := range x.paths {
+		vars[i] = VarName(prefix + p)
+	}
+	return vars
+}
+
+// expander walks expvar data, collecting paths matching segments.
+// Paths, for which skip returns true, are not collected.
+type expander struct {
+	limit int
+	skip  func(path string) bool
+	paths []string
+}
+
+func (x *expander) full() bool {
+	return x.limit > 0 && len(x.paths) >= x.limit
+}
+
+// object matches first segment against object keys.
+func (x *expander) object(obj *jason.Object, segs []pathSegment, path []string) {
+	seg := segs[0]
+	keys := []string{seg.Key}
+	if seg.Pattern != "" {
+		keys = keys[:0]
+		for key := range obj.Map() {
+			if matchWildcard(seg.Pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+	}
+
+	for _, key := range keys {
+		value, err := obj.GetValue(key)
+		if err != nil {
+			continue
+		}
+		x.value(value, obj, seg.Indexes, segs[1:], append(path[:len(path):len(path)], escapeVarKey(key)))
+	}
+}
+
+// value applies indexes of the current segment (the last element
+// of path) to the value, and matches the rest of segments. Parent
+// is an object, holding the value.
+func (x *expander) value(value *jason.Value, parent *jason.Object, indexes []index, segs []pathSegment, path []string) {
+	if x.full() {
+		return
+	}
+	if len(indexes) == 0 {
+		if len(segs) == 0 {
+			p := strings.Join(path, ".")
+			if x.skip == nil || !x.skip(p) {
+				x.paths = append(x.paths, p)
+			}
+			return
+		}
+		if obj, err := value.Object(); err == nil {
+			x.object(obj, segs, path)
+		}
+		return
+	}
+
+	arr, err := value.Array()
+	if err != nil {
+		return
+	}
+	last := path[len(path)-1]
+	if !indexes[0].wildcard {
+		// keep index as is, so it's resolved on every update
+		n, err := indexes[0].resolve(arr, parent)
+		if err != nil {
+			return
+		}
+		p := append(path[:len(path)-1:len(path)-1], last+indexes[0].String())
+		x.value(arr[n], parent, indexes[1:], segs, p)
+		return
+	}
+	for i := range arr {
+		p := append(path[:len(path)-1:len(path)-1], fmt.Sprintf("%s[%d]", last, i))
+		x.value(arr[i], parent, indexes[1:], segs, p)
+	}
+}
+
+// matchWildcard returns true if s matches pattern, where "*"
+// matches any sequence of characters, and "\*" matches "*".
+func matchWildcard(pattern, s string) bool {
+	parts := splitWildcard(pattern)
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return len(s) >= len(part) && strings.HasSuffix(s, part)
+		}
+		j := strings.Index(s, part)
+		if j < 0 {
+			return false
+		}
+		s = s[j+len(part):]
+	}
+	return s == ""
+}
+
+// splitWildcard splits pattern by unescaped "*", unescaping parts.
+func splitWildcard(pattern string) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern) && pattern[i+1] == '*':
+			i++
+			b.WriteByte('*')
+		case c == '*':
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(parts, b.String())
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const wildcardJSON = `{
+	"cmdline": ["./app"],
+	"http": {"requests": {"/api/users": 10, "/api/items": 20, "/health": 1, "v1.2": 5}},
+	"memstats": {"PauseTotalNs": 100, "BySize": [{"Size": 0, "Mallocs": 1}, {"Size": 8, "Mallocs": 50}, {"Size": 16, "Mallocs": 7}]},
+	"codes": {"200": {"count": 3}, "500": {"count": 1}, "skip": 0}
+}`
+
+func TestWildcard(t *testing.T) {
+	tests := []struct {
+		name     VarName
+		wildcard bool
+		static   []string
+	}{
+		{"http.requests.*", true, []string{"http", "requests"}},
+		{"rate:http.requests./api*", true, []string{"http", "requests"}},
+		{"memstats.BySize[*].Mallocs", true, []string{"memstats", "BySize"}},
+		{"memstats.BySize[1].Mallocs", false, []string{"memstats", "BySize"}},
+		{"memstats.Alloc", false, []string{"memstats", "Alloc"}},
+		{`http_requests_total{path="/a[1]"}`, false, []string{`http_requests_total{path="/a[1]"}`}},
+	}
+	for _, test := range tests {
+		if got := test.name.Wildcard(); got != test.wildcard {
+			t.Fatalf("Expecting %s wildcard to be %v, but got %v", test.name, test.wildcard, got)
+		}
+		if got := staticPath(test.name.ToSlice()); !reflect.DeepEqual(got, test.static) {
+			t.Fatalf("Expecting static path of %s to be %v, but got %v", test.name, test.static, got)
+		}
+	}
+}
+
+func TestExpandVar(t *testing.T) {
+	expvar, err := ParseExpvar(strings.NewReader(wildcardJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  VarName
+		limit int
+		want  []VarName
+	}{
+		{"http.requests.*", 0, []VarName{"http.requests./api/items", "http.requests./api/users", "http.requests./health", `http.requests.v1\.2`}},
+		{"rate:http.requests./api*", 0, []VarName{"rate:http.requests./api/items", "rate:http.requests./api/users"}},
+		{"http.requests.*", 2, []VarName{"http.requests./api/items", "http.requests./api/users"}},
+		{"mem:memstats.BySize[*].Mallocs", 0, []VarName{"mem:memstats.BySize[0].Mallocs", "mem:memstats.BySize[1].Mallocs", "mem:memstats.BySize[2].Mallocs"}},
+		{"codes.*.count", 0, []VarName{"codes.200.count", "codes.500.count"}},
+		{"missing.*", 0, []VarName{}},
+		{"req=rate:http.requests./api*", 0, []VarName{"req=rate:http.requests./api/items", "req=rate:http.requests./api/users"}},
+	}
+	for _, test := range tests {
+		got := ExpandVar(expvar, test.name, test.limit)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("Expecting %s to be expanded to %v, but got %v", test.name, test.want, got)
+		}
+		// expanded vars are resolvable
+		for _, name := range got {
+			if _, err := expvar.Lookup(name.ToSlice()...); err != nil {
+				t.Fatalf("Expecting %s to be found: %v", name, err)
+			}
+		}
+	}
+
+	if v, _ := expvar.Lookup("memstats", "BySize[1]", "Size"); v == nil {
+		t.Fatal("Expecting indexed value to be found")
+	} else if n, _ := v.Int64(); n != 8 {
+		t.Fatalf("Expecting BySize[1].Size to be 8, but got %d", n)
+	}
+	if _, err := expvar.Lookup("memstats", "BySize[3]", "Size"); err == nil {
+		t.Fatal("Expecting index out of range to fail")
+	}
+
+	if s := VarName("memstats.BySize[1].Mallocs").Short(); s != "BySize[1].Mallocs" {
+		t.Fatalf("Expecting short name to include index, but got %q", s)
+	}
+}
+
+func TestExpandVarEscaped(t *testing.T) {
+	expvar, err := ParseExpvar(strings.NewReader(`{"m": {"a*b": 1, "x[1]": 2, "{y}": 3, "a.c": 4}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := ExpandVar(expvar, "m.*", 0)
+	want := []VarName{`m.a\*b`, `m.a\.c`, `m.x\[1\]`, `m.\{y\}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expecting keys to be escaped as %v, but got %v", want, got)
+	}
+	// escaped keys are not wildcards and match themselves only
+	values := []int64{1, 4, 2, 3}
+	for i, name := range got {
+		if name.Wildcard() {
+			t.Fatalf("Expecting %s not to be wildcard", name)
+		}
+		v, err := expvar.Lookup(name.ToSlice()...)
+		if err != nil {
+			t.Fatalf("Expecting %s to be found: %v", name, err)
+		}
+		if n, _ := v.Int64(); n != values[i] {
+			t.Fatalf("Expecting %s to be %d, but got %d", name, values[i], n)
+		}
+	}
+	if got := staticPath(VarName(`m.x\[1\]`).ToSlice()); !reflect.DeepEqual(got, []string{"m", "x[1]"}) {
+		t.Fatalf("Expecting static path to be unescaped, but got %v", got)
+	}
+	if got := ExpandVar(expvar, `m.a\**`, 0); !reflect.DeepEqual(got, []VarName{`m.a\*b`}) {
+		t.Fatalf("Expecting escaped wildcard to match literally, but got %v", got)
+	}
+
+	// known vars are skipped and not counted to the limit
+	known := map[VarName]bool{`m.a\*b`: true}
+	if got := expandVar(expvar, "m.*", 1, known); !reflect.DeepEqual(got, []VarName{`m.a\.c`}) {
+		t.Fatalf("Expecting known vars to be skipped, but got %v", got)
+	}
+}
+
+func TestServiceExpand(t *testing.T) {
+	defer func(n int) { *maxSeries = n }(*maxSeries)
+	*maxSeries = 3
+
+	expvar, err := ParseExpvar(strings.NewReader(wildcardJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := []VarName{"memstats.PauseTotalNs", "http.requests.*", "codes.*.count", "missing.*"}
+	service := NewService(url.URL{Host: "localhost:1234"}, vars)
+	data := NewUIData(vars)
+	data.Services = []*Service{service}
+
+	// only extracted data is available
+	extracted, err := ExtractExpvar(strings.NewReader(wildcardJSON), service.vars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.update(extracted, nil, time.Now())
+	data.ExpandVars()
+
+	want := []VarName{
+		"memstats.PauseTotalNs",
+		"http.requests./api/items", "http.requests./api/users", "http.requests./health",
+		"codes.200.count", "codes.500.count",
+		"missing.*",
+	}
+	if !reflect.DeepEqual(data.Vars, want) {
+		t.Fatalf("Expecting vars to be expanded to %v, but got %v", want, data.Vars)
+	}
+	if v := service.Value("http.requests./api/users"); v != "10" {
+		t.Fatalf("Expecting expanded var value to be 10, but got %q", v)
+	}
+
+	// vars are kept when keys disappear
+	expvar, _ = ParseExpvar(strings.NewReader(`{"codes": {"404": {"count": 1}}}`))
+	service.update(expvar, nil, time.Now())
+	data.ExpandVars()
+	if fmt.Sprint(service.Expanded("codes.*.count")) != "[codes.200.count codes.404.count codes.500.count]" {
+		t.Fatalf("Expecting new keys to be added, but got %v", service.Expanded("codes.*.count"))
+	}
+	if v := service.Value("codes.200.count"); v != "N/A" {
+		t.Fatalf("Expecting vanished key value to be N/A, but got %q", v)
+	}
+
+	ToggleVar(data, "http.requests.*")
+	if _, ok := service.stacks["http.requests./health"]; ok {
+		t.Fatal("Expecting expanded vars to be removed along with wildcard")
+	}
+	for _, name := range data.Vars {
+		if strings.HasPrefix(string(name), "http.") {
+			t.Fatalf("Expecting %s not to be displayed", name)
+		}
+	}
+}
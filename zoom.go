@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gizak/termui"
+)
+
+// zoomColors are used for series of different services.
+var zoomColors = []termui.Attribute{
+	termui.ColorGreen,
+	termui.ColorYellow,
+	termui.ColorCyan,
+	termui.ColorMagenta,
+	termui.ColorBlue,
+	termui.ColorRed,
+	termui.ColorWhite,
+}
+
+// Zoom is a full-screen view of a single var, showing line chart of
+// its values across services and statistics for the visible window.
+type Zoom struct {
+	Chart  *ZoomChart
+	Legend *termui.List
+}
+
+// NewZoom creates widgets for zoom view.
+func NewZoom() *Zoom {
+	chart := &ZoomChart{Block: *termui.NewBlock()}
+	chart.BorderFg = termui.ColorCyan
+
+	legend := termui.NewList()
+	legend.Border = true
+	legend.BorderLabel = "Visible window"
+	legend.BorderFg = termui.ColorCyan
+
+	return &Zoom{
+		Chart:  chart,
+		Legend: legend,
+	}
+}
+
+// Update updates chart and legend with the var values in services,
+// placing widgets below the given height.
+func (z *Zoom) Update(services []*Service, name VarName, y int) {
+	tw, th := termui.TermWidth(), termui.TermHeight()
+
+	z.Chart.Var = name
+	z.Chart.Series = z.Chart.Series[:0]
+	for i, service := range services {
+		series := ZoomSeries{
+			Name:  service.Name,
+			Color: zoomColors[i%len(zoomColors)],
+		}
+		if stack, ok := service.stacks[name]; ok {
+			series.Values, series.Times = stack.FloatValues()
+		}
+		z.Chart.Series = append(z.Chart.Series, series)
+	}
+
+	z.Legend.Height = len(services) + 2
+	z.Legend.Width = tw
+	z.Legend.Y = th - z.Legend.Height
+
+	z.Chart.BorderLabel = fmt.Sprintf("%s (tab - next var, z - close)", name.Title())
+	z.Chart.Y = y
+	z.Chart.Width = tw
+	z.Chart.Height = z.Legend.Y - y
+	z.Chart.Align()
+
+	l := z.Chart.layout()
+	z.Legend.Items = nil
+	for _, series := range z.Chart.Series {
+		values := series.Visible(l.start, l.end)
+		line := fmt.Sprintf("%s: no data", series.Name)
+		if len(values) > 0 {
+			st := Stats(values)
+			line = fmt.Sprintf("%s: min %s  avg %s  max %s  p95 %s", series.Name,
+				name.Format(st.Min), name.Format(st.Avg), name.Format(st.Max), name.Format(st.P95))
+		}
+		z.Legend.Items = append(z.Legend.Items, fmt.Sprintf("[%s](%s)", line, colorName(series.Color)))
+	}
+}
+
+// Buffers returns widgets to render.
+func (z *Zoom) Buffers() []termui.Bufferer {
+	return []termui.Bufferer{z.Chart, z.Legend}
+}
+
+// ZoomSeries represents values of the var for single service.
+type ZoomSeries struct {
+	Name   string
+	Color  termui.Attribute
+	Values []float64
+	Times  []time.Time
+}
+
+// Visible returns values within the time window.
+func (s ZoomSeries) Visible(start, end time.Time) []float64 {
+	var ret []float64
+	for i, t := range s.Times {
+		if !t.Before(start) && !t.After(end) {
+			ret = append(ret, s.Values[i])
+		}
+	}
+	return ret
+}
+
+// SeriesStats represents statistics for the series values.
+type SeriesStats struct {
+	Min, Avg, Max, P95 float64
+}
+
+// Stats calculates statistics for non-empty values slice.
+func Stats(values []float64) SeriesStats {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	p95 := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	if p95 < 0 {
+		p95 = 0
+	}
+	return SeriesStats{
+		Min: sorted[0],
+		Avg: sum / float64(len(sorted)),
+		Max: sorted[len(sorted)-1],
+		P95: sorted[p95],
+	}
+}
+
+// ZoomChart is a braille line chart widget, with Y axis labels
+// formatted according to var kind, and X axis in wall-clock time.
+type ZoomChart struct {
+	termui.Block
+	Var    VarName
+	Series []ZoomSeries
+}
+
+// zoomLayout holds calculated chart geometry and scales.
+type zoomLayout struct {
+	start, end time.Time
+	lo, hi     float64
+	labels     map[int]string // Y labels by row
+	labelW     int
+	plot       image.Rectangle // plot area, without axes
+}
+
+// layout calculates visible time window, Y range and chart geometry.
+func (c *ZoomChart) layout() zoomLayout {
+	inner := c.InnerBounds()
+	var l zoomLayout
+
+	// Y labels width depends on values range, and range depends
+	// on visible window, so calculate it twice.
+	for i := 0; i < 2; i++ {
+		cols := inner.Dx() - l.labelW - 1
+		if cols < 1 {
+			cols = 1
+		}
+		l.start, l.end = c.window(2 * cols)
+		l.lo, l.hi = c.yRange(l.start, l.end)
+
+		l.plot = image.Rect(inner.Min.X+l.labelW+1, inner.Min.Y, inner.Max.X, inner.Max.Y-2)
+		l.labels = make(map[int]string)
+		l.labelW = 0
+		rows := l.plot.Dy()
+		for row := 0; row < rows; row += 3 {
+			v := l.lo
+			if rows > 1 {
+				v += (l.hi - l.lo) * float64(row) / float64(rows-1)
+			}
+			label := c.Var.Format(v)
+			l.labels[l.plot.Max.Y-1-row] = label
+			if n := utf8.RuneCountInString(label); n > l.labelW {
+				l.labelW = n
+			}
+		}
+	}
+	return l
+}
+
+// window returns time window to display the given number of points,
+// ending with the latest value.
+func (c *ZoomChart) window(points int) (time.Time, time.Time) {
+	var end time.Time
+	var longest []time.Time
+	for _, s := range c.Series {
+		if len(s.Times) == 0 {
+			continue
+		}
+		if t := s.Times[len(s.Times)-1]; t.After(end) {
+			end = t
+		}
+		if len(s.Times) > len(longest) {
+			longest = s.Times
+		}
+	}
+
+	// estimate polling interval as the median distance between points
+	step := *interval
+	if len(longest) > 1 {
+		var diffs []time.Duration
+		for i := 1; i < len(longest); i++ {
+			diffs = append(diffs, longest[i].Sub(longest[i-1]))
+		}
+		sort.Slice(diffs, func(i, j int) bool { return diffs[i] < diffs[j] })
+		if d := diffs[len(diffs)/2]; d > 0 {
+			step = d
+		}
+	}
+	return end.Add(-step * time.Duration(points-1)), end
+}
+
+// yRange returns range of visible values, with some padding.
+func (c *ZoomChart) yRange(start, end time.Time) (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range c.Series {
+		for _, v := range s.Visible(start, end) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	if math.IsInf(lo, 0) {
+		return 0, 1
+	}
+	if lo == hi {
+		return lo - 1, hi + 1
+	}
+	pad := (hi - lo) * 0.05
+	if lo >= 0 && lo-pad < 0 {
+		return 0, hi + pad
+	}
+	return lo - pad, hi + pad
+}
+
+// braille dots bits, by [x][y] position within the cell
+var brailleDots = [2][4]rune{
+	{0x01, 0x02, 0x04, 0x40},
+	{0x08, 0x10, 0x20, 0x80},
+}
+
+// Buffer implements termui.Bufferer.
+func (c *ZoomChart) Buffer() termui.Buffer {
+	buf := c.Block.Buffer()
+	l := c.layout()
+	if l.plot.Dx() < 1 || l.plot.Dy() < 1 {
+		return buf
+	}
+	axes := termui.ColorWhite
+
+	// axes and Y labels
+	axisX, axisY := l.plot.Min.X-1, l.plot.Max.Y
+	for y := l.plot.Min.Y; y < axisY; y++ {
+		buf.Set(axisX, y, termui.Cell{Ch: termui.VERTICAL_LINE, Fg: axes, Bg: c.Bg})
+		if label, ok := l.labels[y]; ok {
+			x := axisX - utf8.RuneCountInString(label)
+			for _, r := range label {
+				buf.Set(x, y, termui.Cell{Ch: r, Fg: axes, Bg: c.Bg})
+				x++
+			}
+		}
+	}
+	buf.Set(axisX, axisY, termui.Cell{Ch: termui.BOTTOM_LEFT, Fg: axes, Bg: c.Bg})
+	for x := l.plot.Min.X; x < l.plot.Max.X; x++ {
+		buf.Set(x, axisY, termui.Cell{Ch: termui.HORIZONTAL_LINE, Fg: axes, Bg: c.Bg})
+	}
+
+	// X labels, wall-clock time
+	span := l.end.Sub(l.start)
+	for x := l.plot.Min.X; x+8 <= l.plot.Max.X; x += 12 {
+		t := l.start.Add(span * time.Duration(x-l.plot.Min.X) / time.Duration(l.plot.Dx()))
+		buf.Set(x, axisY, termui.Cell{Ch: '┬', Fg: axes, Bg: c.Bg})
+		for i, r := range t.Format("15:04:05") {
+			buf.Set(x+i, axisY+1, termui.Cell{Ch: r, Fg: axes, Bg: c.Bg})
+		}
+	}
+
+	// series, as braille dots connected with lines
+	w, h := 2*l.plot.Dx(), 4*l.plot.Dy()
+	toPixel := func(t time.Time, v float64) (int, int) {
+		px := 0
+		if span > 0 {
+			px = int(float64(t.Sub(l.start)) / float64(span) * float64(w-1))
+		}
+		py := int((v - l.lo) / (l.hi - l.lo) * float64(h-1))
+		return px, py
+	}
+	for _, s := range c.Series {
+		cells := make(map[image.Point]rune)
+		set := func(px, py int) {
+			if px < 0 || px >= w || py < 0 || py >= h {
+				return
+			}
+			p := image.Pt(l.plot.Min.X+px/2, l.plot.Max.Y-1-py/4)
+			cells[p] |= brailleDots[px%2][3-py%4]
+		}
+
+		prevX, prevY, prev := 0, 0, false
+		for i, t := range s.Times {
+			if t.Before(l.start) {
+				continue
+			}
+			px, py := toPixel(t, s.Values[i])
+			if prev {
+				drawLine(prevX, prevY, px, py, set)
+			}
+			set(px, py)
+			prevX, prevY, prev = px, py, true
+		}
+		for p, dots := range cells {
+			cell := buf.At(p.X, p.Y)
+			if cell.Ch >= 0x2800 && cell.Ch <= 0x28FF {
+				dots |= cell.Ch - 0x2800
+			}
+			buf.Set(p.X, p.Y, termui.Cell{Ch: 0x2800 + dots, Fg: s.Color, Bg: c.Bg})
+		}
+	}
+	return buf
+}
+
+// drawLine calls set for all points of line between (x0, y0) and (x1, y1).
+func drawLine(x0, y0, x1, y1 int, set func(x, y int)) {
+	dx, dy := x1-x0, y1-y0
+	steps := int(math.Max(math.Abs(float64(dx)), math.Abs(float64(dy))))
+	if steps == 0 {
+		set(x0, y0)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		set(x0+int(math.Round(float64(dx*i)/float64(steps))), y0+int(math.Round(float64(dy*i)/float64(steps))))
+	}
+}
+
+// colorName returns termui markup color name.
+func colorName(color termui.Attribute) string {
+	switch color {
+	case termui.ColorGreen:
+		return "fg-green"
+	case termui.ColorYellow:
+		return "fg-yellow"
+	case termui.ColorCyan:
+		return "fg-cyan"
+	case termui.ColorMagenta:
+		return "fg-magenta"
+	case termui.ColorBlue:
+		return "fg-blue"
+	case termui.ColorRed:
+		return "fg-red"
+	}
+	return "fg-white"
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gizak/termui"
+)
+
+func TestStats(t *testing.T) {
+	var values []float64
+	for i := 100; i > 0; i-- {
+		values = append(values, float64(i))
+	}
+	st := Stats(values)
+	if st.Min != 1 || st.Max != 100 || st.Avg != 50.5 || st.P95 != 95 {
+		t.Fatalf("Wrong stats: %+v", st)
+	}
+}
+
+func TestZoomChart(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 0, 0, time.UTC)
+	stack := NewStack()
+	for i := 0; i < 200; i++ {
+		stack.PushAt(int64(i*1024*1024), start.Add(time.Duration(i)*time.Second))
+	}
+	values, times := stack.FloatValues()
+	if len(values) != 200 {
+		t.Fatalf("Expecting 200 values, but got %d", len(values))
+	}
+
+	c := &ZoomChart{Block: *termui.NewBlock(), Var: "mem:memstats.Alloc"}
+	c.Series = []ZoomSeries{{Name: "app", Color: termui.ColorGreen, Values: values, Times: times}}
+	c.Width, c.Height = 60, 20
+	c.Align()
+
+	l := c.layout()
+	// 2 points per cell for the plot width
+	if want := 2*l.plot.Dx() - 1; int(l.end.Sub(l.start)/time.Second) != want {
+		t.Fatalf("Expecting window of %d seconds, but got %v", want, l.end.Sub(l.start))
+	}
+	if !l.end.Equal(times[199]) {
+		t.Fatalf("Expecting window to end with the latest value, but got %v", l.end)
+	}
+
+	buf := c.Buffer()
+	var lines []string
+	for y := 0; y < c.Height; y++ {
+		var line []rune
+		for x := 0; x < c.Width; x++ {
+			ch := buf.At(x, y).Ch
+			if ch == 0 {
+				ch = ' '
+			}
+			line = append(line, ch)
+		}
+		lines = append(lines, string(line))
+	}
+	text := strings.Join(lines, "\n")
+	for _, want := range []string{"91MB", "03:05:36"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("Expecting chart to contain %q, but got:\n%s", want, text)
+		}
+	}
+}